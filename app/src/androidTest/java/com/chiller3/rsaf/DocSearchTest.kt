@@ -0,0 +1,126 @@
+/*
+ * SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package com.chiller3.rsaf
+
+import androidx.test.ext.junit.runners.AndroidJUnit4
+import androidx.test.platform.app.InstrumentationRegistry
+import com.chiller3.rsaf.binding.rcbridge.RbError
+import com.chiller3.rsaf.binding.rcbridge.RbSearchOptions
+import com.chiller3.rsaf.binding.rcbridge.Rcbridge
+import com.chiller3.rsaf.extension.toException
+import com.chiller3.rsaf.rclone.RcloneConfig
+import com.chiller3.rsaf.rclone.RcloneRpc
+import org.junit.After
+import org.junit.Assert.assertEquals
+import org.junit.Before
+import org.junit.Test
+import org.junit.runner.RunWith
+import java.io.File
+
+@RunWith(AndroidJUnit4::class)
+class DocSearchTest {
+    private lateinit var remote: String
+    private lateinit var rootDir: File
+
+    @Before
+    fun createTempRemote() {
+        val appContext = InstrumentationRegistry.getInstrumentation().targetContext
+
+        remote = "testing." + RandomUtils.generatePassword(16, RandomUtils.ASCII_ALPHANUMERIC)
+        rootDir = File(appContext.cacheDir, remote)
+
+        // Some queries match a directory's name. The directory itself should be returned, but not
+        // the files inside it.
+        for (path in arrayOf("foo/bar/baz.txt", "foo.d/x/y.txt", "abc/def/ghi.jpg")) {
+            File(rootDir, path).apply {
+                parentFile!!.mkdirs()
+                writeText(path)
+            }
+        }
+
+        val iq = RcloneRpc.InteractiveConfiguration(remote)
+        while (true) {
+            val (_, option) = iq.question ?: break
+
+            when (option.name) {
+                "type" -> iq.submit("alias")
+                "remote" -> iq.submit(rootDir.toString())
+                "config_fs_advanced" -> iq.submit("false")
+                else -> throw IllegalStateException("Unexpected question: ${option.name}")
+            }
+        }
+    }
+
+    @After
+    fun deleteTempRemote() {
+        RcloneConfig.deleteRemote(remote)
+        rootDir.deleteRecursively()
+    }
+
+    private fun search(query: String, mode: Long, caseSensitive: Boolean = true): Set<String> {
+        val options = RbSearchOptions().apply {
+            this.mode = mode
+            this.caseSensitive = caseSensitive
+        }
+        val error = RbError()
+        val listing = Rcbridge.rbDocSearch("$remote:", query, options, null, error)
+            ?: throw error.toException("rbDocSearch")
+        val results = mutableSetOf<String>()
+
+        try {
+            while (true) {
+                val entries = listing.next(100, error)
+                    ?: throw error.toException("next")
+                if (entries.size() == 0L) {
+                    break
+                }
+
+                for (i in 0 until entries.size()) {
+                    results.add(entries.get(i).doc.removePrefix("$remote:"))
+                }
+            }
+        } finally {
+            listing.close()
+        }
+
+        return results
+    }
+
+    @Test
+    fun substringMatchesLastPathElementOnly() {
+        assertEquals(setOf("foo/bar/baz.txt"), search("baz", Rcbridge.RbSearchModeSubstring))
+        assertEquals(
+            setOf("foo", "foo.d"),
+            search("foo", Rcbridge.RbSearchModeSubstring),
+        )
+        // This would match "foo/bar" if the substring could span path elements.
+        assertEquals(emptySet<String>(), search("o/b", Rcbridge.RbSearchModeSubstring))
+        assertEquals(
+            setOf("abc/def/ghi.jpg"),
+            search("GHI", Rcbridge.RbSearchModeSubstring, caseSensitive = false),
+        )
+    }
+
+    @Test
+    fun regexMatchesLastPathElementOnly() {
+        assertEquals(
+            setOf("foo/bar/baz.txt", "foo.d/x/y.txt"),
+            search(".*\\.txt", Rcbridge.RbSearchModeRegex),
+        )
+        // This would match "foo/bar/baz.txt" if the regex could span path elements.
+        assertEquals(emptySet<String>(), search("foo.*txt", Rcbridge.RbSearchModeRegex))
+        assertEquals(
+            setOf("abc/def"),
+            search("D[E]F", Rcbridge.RbSearchModeRegex, caseSensitive = false),
+        )
+    }
+
+    @Test
+    fun globMatchesNestedPaths() {
+        assertEquals(setOf("abc/def/ghi.jpg"), search("*.jpg", Rcbridge.RbSearchModeGlob))
+        assertEquals(setOf("foo/bar/baz.txt"), search("/foo/**.txt", Rcbridge.RbSearchModeGlob))
+    }
+}
@@ -6,6 +6,10 @@
 package com.chiller3.rsaf
 
 import androidx.test.ext.junit.runners.AndroidJUnit4
+import com.chiller3.rsaf.binding.rcbridge.RbConfigExportOptions
+import com.chiller3.rsaf.binding.rcbridge.RbError
+import com.chiller3.rsaf.binding.rcbridge.Rcbridge
+import com.chiller3.rsaf.extension.toException
 import com.chiller3.rsaf.rclone.RcloneConfig
 import com.chiller3.rsaf.rclone.RcloneRpc
 import org.junit.After
@@ -86,7 +90,7 @@ class ImportExportTest {
 
     @After
     fun deleteTempRemote() {
-        RcloneRpc.deleteRemote(remote)
+        RcloneConfig.deleteRemote(remote)
     }
 
     @Test
@@ -99,7 +103,7 @@ class ImportExportTest {
         assertEquals("alias", config[remote]!!["type"])
         assertEquals(target, config[remote]!!["remote"])
 
-        RcloneRpc.deleteRemote(remote)
+        RcloneConfig.deleteRemote(remote)
 
         val inputStream = ByteArrayInputStream(outputStream.toByteArray())
         RcloneConfig.importConfiguration(inputStream, RcloneConfig.Password(""))
@@ -115,7 +119,7 @@ class ImportExportTest {
         val rawConfig = outputStream.toString(Charsets.UTF_8)
         assertTrue(isEncryptedConfig(rawConfig))
 
-        RcloneRpc.deleteRemote(remote)
+        RcloneConfig.deleteRemote(remote)
 
         assertThrows(RcloneConfig.BadPasswordException::class.java) {
             val inputStream = ByteArrayInputStream(outputStream.toByteArray())
@@ -127,4 +131,49 @@ class ImportExportTest {
         RcloneConfig.importConfiguration(inputStream, RcloneConfig.Password("test"))
         assertTrue(RcloneRpc.remoteNames.contains(remote))
     }
+
+    @Test
+    fun testExportApiEncrypted() {
+        val options = RbConfigExportOptions().apply {
+            keepSecrets = true
+            password = "test"
+        }
+        val error = RbError()
+        val result = Rcbridge.rbConfigExport(options, error)
+            ?: throw error.toException("rbConfigExport")
+        assertTrue(isEncryptedConfig(result.data))
+
+        RcloneConfig.deleteRemote(remote)
+
+        // Importing goes through rclone's own config decryption, so this checks that the export
+        // API encrypts in a format that rclone can read.
+        assertThrows(RcloneConfig.BadPasswordException::class.java) {
+            val inputStream = ByteArrayInputStream(result.data.toByteArray(Charsets.UTF_8))
+            RcloneConfig.importConfiguration(inputStream, RcloneConfig.Password("wrong"))
+        }
+        assertFalse(RcloneRpc.remoteNames.contains(remote))
+
+        val inputStream = ByteArrayInputStream(result.data.toByteArray(Charsets.UTF_8))
+        RcloneConfig.importConfiguration(inputStream, RcloneConfig.Password("test"))
+        assertTrue(RcloneRpc.remoteNames.contains(remote))
+
+        val outputStream = ByteArrayOutputStream()
+        RcloneConfig.exportConfiguration(outputStream, RcloneConfig.Password(""))
+
+        val config = parseConfig(outputStream.toString(Charsets.UTF_8))
+        assertEquals(target, config[remote]!!["remote"])
+    }
+
+    @Test
+    fun testRotatePassword() {
+        RcloneConfig.rotatePassword()
+        assertTrue(RcloneRpc.remoteNames.contains(remote))
+
+        // The config must still be usable after a round trip through the config file.
+        val outputStream = ByteArrayOutputStream()
+        RcloneConfig.exportConfiguration(outputStream, RcloneConfig.Password(""))
+
+        val config = parseConfig(outputStream.toString(Charsets.UTF_8))
+        assertEquals(target, config[remote]!!["remote"])
+    }
 }
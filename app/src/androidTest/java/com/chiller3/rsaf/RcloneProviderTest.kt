@@ -14,6 +14,7 @@ import android.system.OsConstants
 import androidx.test.ext.junit.runners.AndroidJUnit4
 import androidx.test.platform.app.InstrumentationRegistry
 import com.chiller3.rsaf.binding.rcbridge.Rcbridge
+import com.chiller3.rsaf.rclone.RcloneConfig
 import com.chiller3.rsaf.rclone.RcloneProvider
 import com.chiller3.rsaf.rclone.RcloneRpc
 import org.junit.After
@@ -142,7 +143,7 @@ class RcloneProviderTest {
 
     @After
     fun deleteTempRemote() {
-        RcloneRpc.deleteRemote(remote)
+        RcloneConfig.deleteRemote(remote)
         rootDir.deleteRecursively()
     }
 
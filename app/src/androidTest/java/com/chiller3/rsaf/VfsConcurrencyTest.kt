@@ -0,0 +1,245 @@
+/*
+ * SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package com.chiller3.rsaf
+
+import androidx.test.ext.junit.runners.AndroidJUnit4
+import androidx.test.platform.app.InstrumentationRegistry
+import com.chiller3.rsaf.binding.rcbridge.RbError
+import com.chiller3.rsaf.binding.rcbridge.Rcbridge
+import com.chiller3.rsaf.extension.toException
+import com.chiller3.rsaf.rclone.RcloneConfig
+import com.chiller3.rsaf.rclone.RcloneRpc
+import org.json.JSONObject
+import org.junit.After
+import org.junit.Assert.assertEquals
+import org.junit.Assert.assertFalse
+import org.junit.Assert.assertTrue
+import org.junit.Before
+import org.junit.Test
+import org.junit.runner.RunWith
+import java.io.File
+import java.net.InetAddress
+import java.net.ServerSocket
+import java.net.Socket
+import java.util.concurrent.CountDownLatch
+import java.util.concurrent.Executors
+import java.util.concurrent.Future
+import java.util.concurrent.TimeUnit
+
+@RunWith(AndroidJUnit4::class)
+class VfsConcurrencyTest {
+    companion object {
+        private const val CHURN_REMOTES = 4
+        private const val STABLE_REMOTES = 4
+        private const val ITERATIONS = 50
+        private const val TIMEOUT_SECS = 60L
+        private const val UNBLOCKED_TIMEOUT_SECS = 10L
+    }
+
+    private val remotes = mutableListOf<String>()
+    private val rootDirs = mutableListOf<File>()
+
+    private fun createRemote(): String {
+        val appContext = InstrumentationRegistry.getInstrumentation().targetContext
+
+        val remote = "testing." + RandomUtils.generatePassword(16, RandomUtils.ASCII_ALPHANUMERIC)
+        val rootDir = File(appContext.cacheDir, remote)
+        rootDir.mkdirs()
+        File(rootDir, "file.txt").writeText(remote)
+
+        val iq = RcloneRpc.InteractiveConfiguration(remote)
+        while (true) {
+            val (_, option) = iq.question ?: break
+
+            when (option.name) {
+                "type" -> iq.submit("alias")
+                "remote" -> iq.submit(rootDir.toString())
+                "config_fs_advanced" -> iq.submit("false")
+                else -> throw IllegalStateException("Unexpected question: ${option.name}")
+            }
+        }
+
+        remotes.add(remote)
+        rootDirs.add(rootDir)
+
+        return remote
+    }
+
+    /**
+     * Create an FTP remote that points to a server which accepts connections, but never sends the
+     * greeting. Creating the fs for this remote blocks until the socket is closed.
+     */
+    private fun createBlockingRemote(server: ServerSocket): String {
+        val remote = "testing." + RandomUtils.generatePassword(16, RandomUtils.ASCII_ALPHANUMERIC)
+
+        val result = Rcbridge.rbRpcCall("config/create", JSONObject()
+            .put("name", remote)
+            .put("type", "ftp")
+            .put("parameters", JSONObject()
+                .put("host", server.inetAddress.hostAddress)
+                .put("port", server.localPort.toString()))
+            .put("opt", JSONObject()
+                .put("nonInteractive", true)
+                .put("obscure", true))
+            .toString())
+        assertEquals(200L, result.status)
+
+        remotes.add(remote)
+
+        return remote
+    }
+
+    private fun listDir(remote: String): Int {
+        val error = RbError()
+        val entries = Rcbridge.rbDocListDir("$remote:", null, error)
+            ?: throw error.toException("rbDocListDir")
+
+        return entries.size().toInt()
+    }
+
+    @Before
+    fun createTempRemotes() {
+        repeat(CHURN_REMOTES + STABLE_REMOTES) {
+            createRemote()
+        }
+    }
+
+    @After
+    fun deleteTempRemotes() {
+        for (remote in remotes) {
+            RcloneConfig.deleteRemote(remote)
+        }
+        for (rootDir in rootDirs) {
+            rootDir.deleteRecursively()
+        }
+    }
+
+    /**
+     * Repeatedly create and shut down the VFS for some remotes while listing other remotes. The
+     * remotes that are not being shut down must never fail and nothing may deadlock.
+     */
+    @Test
+    fun createAndShutdownConcurrently() {
+        val churnRemotes = remotes.subList(0, CHURN_REMOTES)
+        val stableRemotes = remotes.subList(CHURN_REMOTES, remotes.size)
+        val executor = Executors.newFixedThreadPool(remotes.size * 2 + 1)
+        val start = CountDownLatch(1)
+        val futures = mutableListOf<Future<*>>()
+
+        try {
+            for (remote in churnRemotes) {
+                futures.add(executor.submit {
+                    start.await()
+
+                    repeat(ITERATIONS) {
+                        // This can fail if the VFS is shut down by the other thread at just the
+                        // right time. All that matters is that it doesn't affect other remotes.
+                        try {
+                            listDir(remote)
+                        } catch (_: Exception) {
+                        }
+                    }
+                })
+                futures.add(executor.submit {
+                    start.await()
+
+                    repeat(ITERATIONS) {
+                        Rcbridge.rbCacheClearRemote("$remote:", false)
+                    }
+                })
+            }
+
+            for (remote in stableRemotes) {
+                repeat(2) {
+                    futures.add(executor.submit {
+                        start.await()
+
+                        repeat(ITERATIONS) {
+                            assertEquals(1, listDir(remote))
+                        }
+                    })
+                }
+            }
+
+            futures.add(executor.submit {
+                start.await()
+
+                repeat(ITERATIONS) {
+                    Rcbridge.rbCacheCleanupMaxWaitSeconds()
+                }
+            })
+
+            start.countDown()
+
+            for (future in futures) {
+                // Rethrows any assertion failures from the worker threads.
+                future.get(TIMEOUT_SECS, TimeUnit.SECONDS)
+            }
+        } finally {
+            executor.shutdownNow()
+        }
+    }
+
+    /**
+     * Block the creation of one remote's VFS and make sure that other remotes can still be used in
+     * the meantime.
+     */
+    @Test
+    fun blockedCreationDoesNotAffectOtherRemotes() {
+        val stableRemote = remotes.first()
+        val executor = Executors.newFixedThreadPool(2)
+        val accepted = CountDownLatch(1)
+        val sockets = mutableListOf<Socket>()
+
+        ServerSocket(0, 1, InetAddress.getLoopbackAddress()).use { server ->
+            val blockedRemote = createBlockingRemote(server)
+
+            try {
+                executor.submit {
+                    while (true) {
+                        val socket = try {
+                            server.accept()
+                        } catch (_: Exception) {
+                            break
+                        }
+
+                        synchronized(sockets) {
+                            sockets.add(socket)
+                        }
+                        accepted.countDown()
+                    }
+                }
+
+                val blocked = executor.submit {
+                    // This fails once the server is closed.
+                    try {
+                        listDir(blockedRemote)
+                    } catch (_: Exception) {
+                    }
+                }
+
+                assertTrue(accepted.await(TIMEOUT_SECS, TimeUnit.SECONDS))
+
+                val start = System.nanoTime()
+                assertEquals(1, listDir(stableRemote))
+                val elapsed = TimeUnit.NANOSECONDS.toSeconds(System.nanoTime() - start)
+                assertTrue("Listing took ${elapsed}s", elapsed < UNBLOCKED_TIMEOUT_SECS)
+
+                // The other remote must still be blocked.
+                assertFalse(blocked.isDone)
+
+                server.close()
+                synchronized(sockets) {
+                    sockets.forEach { it.close() }
+                }
+
+                blocked.get(TIMEOUT_SECS, TimeUnit.SECONDS)
+            } finally {
+                executor.shutdownNow()
+            }
+        }
+    }
+}
@@ -6,30 +6,17 @@
 package com.chiller3.rsaf.rclone
 
 import android.util.Log
-import com.chiller3.rsaf.RandomUtils
+import com.chiller3.rsaf.binding.rcbridge.RbAuthorizeSession
 import com.chiller3.rsaf.binding.rcbridge.RbError
 import com.chiller3.rsaf.binding.rcbridge.Rcbridge
-import java.io.InputStream
-import java.net.HttpURLConnection
-import java.net.URL
-
-/**
- * A hacky class that does what `rclone authorize` does.
- *
- * Because the internal APIs aren't sufficient for library usage, this runs the function backing
- * the `rclone authorize` CLI command and relies on parsing the logcat to gather the results.
- */
+import com.chiller3.rsaf.extension.toException
+
+/** A class that does what `rclone authorize` does. */
 object Authorizer {
     private val TAG = Authorizer::class.java.simpleName
-    private const val GO_TAG = "GoLog"
-
-    private val MARKER_CANCEL = randomMarker("cancel")
-    private const val MARKER_URL = "Please go to the following link: "
-    private const val MARKER_CODE_START = "Paste the following into your remote machine --->"
-    private const val MARKER_CODE_STOP = "<---End paste"
 
-    private fun randomMarker(type: String) =
-        "Log marker for '$type': ${RandomUtils.generatePassword(32)}"
+    private val sessionLock = Object()
+    private var session: RbAuthorizeSession? = null
 
     /**
      * Parse the `rclone authorize` command in the format rclone provides in the config question.
@@ -48,53 +35,45 @@ object Authorizer {
             }
             .drop(2)
 
-    private fun logAsIfGo(msg: String) {
-        // This is intentionally logging at the error level because HarmonyOS devices seem to only
-        // preserve error logs.
-        Log.e(GO_TAG, msg)
-    }
-
     private fun authorizeBlockingLocked(cmd: String, listener: AuthorizeListener) {
         val args = parseCmd(cmd)
 
-        Log.d(TAG, "Starting logcat")
-        // We intentionally only capture error logs so that the HarmonyOS behavior is used
-        // everywhere for easier testing.
-        val logcat = ProcessBuilder("logcat", "-v", "raw", "*:S", "$GO_TAG:E")
-            .redirectOutput(ProcessBuilder.Redirect.PIPE)
-            .redirectErrorStream(true)
-            .start()
+        Log.d(TAG, "Starting authorize server for $args")
 
-        try {
-            val markerNow = randomMarker("start")
-            logAsIfGo(markerNow)
+        val error = RbError()
+        val session = Rcbridge.rbAuthorizeStart(args.joinToString("\u0000"), error)
+            ?: throw error.toException("rbAuthorizeStart")
 
-            val server = Thread {
-                Log.d(TAG, "Starting authorize server for $args")
+        synchronized(sessionLock) {
+            this.session = session
+        }
 
-                val error = RbError()
-                if (!Rcbridge.rbAuthorize(args.joinToString("\u0000"), error)) {
-                    // Intentionally no stack trace
-                    Log.w(TAG, "rbAuthorize error: ${error.msg}")
-                }
+        try {
+            val url = session.waitUrl(error)
+            if (url == null) {
+                // Intentionally no stack trace
+                Log.w(TAG, "RbAuthorizeSession.waitUrl error: ${error.msg}")
+                return
+            }
 
-                Log.d(TAG, "Stopped authorize server")
-                cancelLogReader()
-            }.apply { start() }
+            listener.onAuthorizeUrl(url.url)
 
-            try {
-                processLogs(logcat.inputStream, markerNow, listener)
-            } catch (_: Exception) {
-                cancelServer()
-            } finally {
-                server.join()
+            val token = session.wait(error)
+            if (token == null) {
+                Log.w(TAG, "RbAuthorizeSession.wait error: ${error.msg}")
+                return
             }
+
+            listener.onAuthorizeCode(token.token)
         } finally {
-            try {
-                logcat.destroy()
-            } finally {
-                logcat.waitFor()
+            synchronized(sessionLock) {
+                this.session = null
             }
+
+            session.cancel()
+            // Wait for the session to fully finish so that the next call does not fail with EBUSY.
+            session.wait(RbError())
+            Log.d(TAG, "Stopped authorize server")
         }
     }
 
@@ -113,72 +92,13 @@ object Authorizer {
     }
 
     /**
-     * Try to cancel the running `rclone authorize` server.
+     * Cancel the running `rclone authorize` server.
      *
-     * This should hopefully work, but maybe not since we're relying on log parsing. This function
-     * is idempotent and does not throw exceptions.
+     * This function is idempotent and does not throw exceptions.
      */
     fun cancel() {
-        cancelLogReader()
-        cancelServer()
-    }
-
-    private fun processLogs(inputStream: InputStream, startMarker: String,
-                            listener: AuthorizeListener) {
-        var skippedOld = false
-        var inCode = false
-        val code = StringBuilder()
-
-        inputStream.use { stream ->
-            stream.bufferedReader().use { reader ->
-                while (true) {
-                    val line = reader.readLine() ?: break
-
-                    if (!skippedOld) {
-                        if (line.contains(startMarker)) {
-                            skippedOld = true
-                        }
-                        continue
-                    }
-
-                    if (line.contains(MARKER_CANCEL)) {
-                        break
-                    }
-
-                    if (!inCode) {
-                        val pos = line.indexOf(MARKER_URL)
-                        if (pos >= 0) {
-                            RcloneRpc.authorizeUrl()?.let(listener::onAuthorizeUrl)
-                            continue
-                        }
-                    }
-
-                    if (line.contains(MARKER_CODE_START)) {
-                        inCode = true
-                    } else if (line.contains(MARKER_CODE_STOP)) {
-                        @Suppress("AssignedValueIsNeverRead")
-                        inCode = false
-                        listener.onAuthorizeCode(code.toString())
-                        break
-                    } else if (inCode) {
-                        code.append(line)
-                    }
-                }
-            }
-        }
-
-        Log.d(TAG, "Stopped log parser")
-    }
-
-    private fun cancelLogReader() {
-        logAsIfGo(MARKER_CANCEL)
-    }
-
-    private fun cancelServer() {
-        try {
-            RcloneRpc.authorizeCancel()
-        } catch (e: Exception) {
-            Log.w(TAG, "Error when cancelling authorize server", e)
+        synchronized(sessionLock) {
+            session?.cancel()
         }
     }
 
@@ -187,4 +107,4 @@ object Authorizer {
 
         fun onAuthorizeCode(code: String)
     }
-}
\ No newline at end of file
+}
@@ -12,6 +12,7 @@ import android.system.ErrnoException
 import android.system.OsConstants
 import android.util.Log
 import com.chiller3.rsaf.RandomUtils
+import com.chiller3.rsaf.binding.rcbridge.RbConfigExportOptions
 import com.chiller3.rsaf.binding.rcbridge.RbError
 import com.chiller3.rsaf.binding.rcbridge.Rcbridge
 import com.chiller3.rsaf.extension.toException
@@ -20,7 +21,6 @@ import java.io.File
 import java.io.IOException
 import java.io.InputStream
 import java.io.OutputStream
-import kotlin.io.path.inputStream
 import kotlin.io.path.outputStream
 
 object RcloneConfig {
@@ -100,6 +100,46 @@ object RcloneConfig {
             }
         }
 
+    /**
+     * Replace the rclone config encryption password with a newly generated one. The config file is
+     * re-encrypted before the new password is stored and then reloaded to verify that the stored
+     * password works. If any step fails, both the file and the stored password are reverted.
+     */
+    fun rotatePassword() {
+        synchronized(globalStateLock) {
+            synchronized(passwordStore) {
+                val oldPassword = hardwareWrappedPassword
+                val newPassword = Password(RandomUtils.generatePassword(128))
+                val error = RbError()
+
+                if (!Rcbridge.rbConfigChangePassword(oldPassword.value, newPassword.value, error)) {
+                    throw error.toException("rbConfigChangePassword")
+                }
+
+                try {
+                    passwordStore.password = newPassword.value
+                    setDefaultConfigLocked()
+                    loadLocked(false)
+                } catch (e: Exception) {
+                    Log.w(TAG, "Failed to rotate config password; reverting", e)
+
+                    val reverted = Rcbridge.rbConfigChangePassword(
+                        newPassword.value, oldPassword.value, error)
+                    if (!reverted) {
+                        Log.e(TAG, "Failed to revert config file password: ${error.msg}")
+                    }
+                    passwordStore.password = oldPassword.value
+                    setDefaultConfigLocked()
+
+                    throw e
+                }
+            }
+        }
+
+        Log.i(TAG, "Rotated config password")
+        notifyConfigChanged()
+    }
+
     private fun setConfigLocked(path: String, password: Password) {
         val error = RbError()
 
@@ -120,10 +160,15 @@ object RcloneConfig {
         setConfigLocked(appConfigFile.toString(), hardwareWrappedPassword)
     }
 
-    private fun loadLocked(deleteCacheDir: Boolean) {
+    /**
+     * Load the config file and return the remotes whose config changed. Only the changed remotes'
+     * VFS instances are shut down.
+     */
+    private fun loadLocked(deleteCacheDir: Boolean): List<String> {
         val error = RbError()
 
-        if (!Rcbridge.rbConfigLoad(deleteCacheDir, error)) {
+        val changed = Rcbridge.rbConfigLoad(deleteCacheDir, error)
+        if (changed == null) {
             if (error.code.toInt() == OsConstants.EIO) {
                 // rclone does not have a distinct error type for this, so we're stuck with doing
                 // string matching
@@ -134,6 +179,10 @@ object RcloneConfig {
 
             throw error.toException("rbConfigLoad")
         }
+
+        return (0 until changed.size()).map { changed.get(it) }.also {
+            Log.d(TAG, "Changed remotes after loading config: $it")
+        }
     }
 
     private fun saveLocked() {
@@ -179,21 +228,23 @@ object RcloneConfig {
         }
     }
 
+    /**
+     * Export the entire config, including secrets. This is done in memory so that the config file
+     * and rclone's global config password are never touched.
+     */
     fun exportConfiguration(output: OutputStream, password: Password) {
-        withTempFile(applicationContext, "export.conf") { tempConfig ->
-            synchronized(globalStateLock) {
-                try {
-                    setConfigLocked(tempConfig.toString(), password)
-                    saveLocked()
-                } finally {
-                    setDefaultConfigLocked()
-                }
-            }
+        val options = RbConfigExportOptions().apply {
+            keepSecrets = true
+            this.password = password.value
+        }
+        val error = RbError()
 
-            tempConfig.inputStream().use {
-                it.copyTo(output)
-            }
+        val result = synchronized(globalStateLock) {
+            Rcbridge.rbConfigExport(options, error)
+                ?: throw error.toException("rbConfigExport")
         }
+
+        output.write(result.data.toByteArray(Charsets.UTF_8))
     }
 
     fun exportConfigurationUri(uri: Uri, password: Password) {
@@ -219,6 +270,33 @@ object RcloneConfig {
         }
     }
 
+    /**
+     * Rename a remote. This also moves the VFS cache so that pending uploads are kept. The config
+     * is left unchanged if any step fails.
+     */
+    fun renameRemote(oldRemote: String, newRemote: String) {
+        synchronized(globalStateLock) {
+            val error = RbError()
+            if (!Rcbridge.rbConfigRenameSection(oldRemote, newRemote, error)) {
+                throw error.toException("rbConfigRenameSection")
+            }
+
+            notifyConfigChanged()
+        }
+    }
+
+    /** Delete a remote along with its VFS cache. */
+    fun deleteRemote(remote: String) {
+        synchronized(globalStateLock) {
+            val error = RbError()
+            if (!Rcbridge.rbConfigDeleteSection(remote, error)) {
+                throw error.toException("rbConfigDeleteSection")
+            }
+
+            notifyConfigChanged()
+        }
+    }
+
     class BadPasswordException(message: String?, cause: Throwable? = null)
         : Exception(message, cause)
 
@@ -124,7 +124,8 @@ class RclonePasswordStore(context: Context) {
             }
         }
         set(value) {
-            prefs.edit {
+            // The config file can't be decrypted if it's rotated and the new password is lost.
+            prefs.edit(commit = true) {
                 val encryptedKey = encryptKey(KEY_RCLONE_CONFIG_PASS)
                 if (value == null) {
                     remove(encryptedKey.data)
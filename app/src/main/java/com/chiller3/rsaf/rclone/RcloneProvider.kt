@@ -16,6 +16,9 @@ import android.database.Cursor
 import android.database.MatrixCursor
 import android.graphics.Bitmap
 import android.graphics.Point
+import android.net.ConnectivityManager
+import android.net.Network
+import android.net.NetworkCapabilities
 import android.os.BadParcelableException
 import android.os.CancellationSignal
 import android.os.Handler
@@ -43,6 +46,8 @@ import com.chiller3.rsaf.Notifications
 import com.chiller3.rsaf.Permissions
 import com.chiller3.rsaf.Preferences
 import com.chiller3.rsaf.R
+import com.chiller3.rsaf.binding.rcbridge.RbCancelToken
+import com.chiller3.rsaf.binding.rcbridge.RbChangeListener
 import com.chiller3.rsaf.binding.rcbridge.RbDirEntry
 import com.chiller3.rsaf.binding.rcbridge.RbError
 import com.chiller3.rsaf.binding.rcbridge.RbFile
@@ -322,7 +327,7 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
          * This does not throw.
          */
         private fun documentExists(documentId: String): Boolean =
-            Rcbridge.rbDocStat(documentId, null) != null
+            Rcbridge.rbDocStat(documentId, false, null, null) != null
 
         /**
          * Check if a document is a directory.
@@ -330,15 +335,15 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
          * This does not throw.
          */
         private fun documentIsDir(documentId: String): Boolean {
-            val stat = Rcbridge.rbDocStat(documentId, null) ?: return false
+            val stat = Rcbridge.rbDocStat(documentId, false, null, null) ?: return false
             return OsConstants.S_ISDIR(stat.mode.toInt())
         }
 
         /**
          * Add a cursor row corresponding to a document directory entry.
          *
-         * If the MIME type cannot be determined from the filename, then it is set to
-         * [MIME_TYPE_BINARY].
+         * The MIME type reported by the backend is preferred. If the backend does not know the MIME
+         * type and it cannot be determined from the filename, then it is set to [MIME_TYPE_BINARY].
          */
         private fun addRowByDirEntry(row: MatrixCursor.RowBuilder, entry: RbDirEntry,
                                      allowThumbnails: Boolean) {
@@ -349,8 +354,13 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
                 flags = flags or DocumentsContract.Document.FLAG_DIR_SUPPORTS_CREATE
                 mimeType = DocumentsContract.Document.MIME_TYPE_DIR
             } else {
-                val ext = entry.name.substringAfterLast('.', "")
-                mimeType = MimeTypeMap.getSingleton().getMimeTypeFromExtension(ext) ?: ""
+                // rclone falls back to MIME_TYPE_BINARY if it can't determine the MIME type, but
+                // Android may know about more file extensions.
+                mimeType = entry.mimeType.takeIf { it != MIME_TYPE_BINARY } ?: ""
+                if (mimeType.isEmpty()) {
+                    val ext = entry.name.substringAfterLast('.', "")
+                    mimeType = MimeTypeMap.getSingleton().getMimeTypeFromExtension(ext) ?: ""
+                }
                 if (mimeType.isEmpty()) {
                     mimeType = MIME_TYPE_BINARY
                 }
@@ -413,6 +423,31 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
         }
     }
 
+    private val networkCallback = object : ConnectivityManager.NetworkCallback() {
+        override fun onCapabilitiesChanged(network: Network, capabilities: NetworkCapabilities) {
+            val metered = !capabilities.hasCapability(NetworkCapabilities.NET_CAPABILITY_NOT_METERED)
+            Rcbridge.rbSetNetworkState(true, metered)
+        }
+
+        override fun onLost(network: Network) {
+            Rcbridge.rbSetNetworkState(false, false)
+        }
+    }
+
+    private val changeListener = object : RbChangeListener {
+        override fun onChange(doc: String, isDir: Boolean) {
+            if (isDir) {
+                notifyChildrenChanged(doc)
+            }
+
+            try {
+                notifyChildrenChanged(splitPath(doc).first)
+            } catch (e: Exception) {
+                Log.w(TAG, "Failed to notify parent of changed document: $doc", e)
+            }
+        }
+    }
+
     private fun waitUntilUploadsDone(documentId: String) {
         val path = splitComponents(documentId)
 
@@ -464,6 +499,11 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
             IntentFilter(KeyChain.ACTION_TRUST_STORE_CHANGED),
         )
 
+        context.getSystemService(ConnectivityManager::class.java)
+            .registerDefaultNetworkCallback(networkCallback)
+
+        Rcbridge.rbSetChangeListener(changeListener)
+
         return true
     }
 
@@ -481,6 +521,10 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
     }
 
     override fun shutdown() {
+        Rcbridge.rbSetChangeListener(null)
+
+        context!!.getSystemService(ConnectivityManager::class.java)
+            .unregisterNetworkCallback(networkCallback)
         context!!.unregisterReceiver(trustStoreListener)
 
         prefs.unregisterListener(this)
@@ -609,7 +653,7 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
         val error = RbError()
 
         return MatrixCursor(getDocumentProjection(projection)).apply {
-            val entries = Rcbridge.rbDocListDir(parentDocumentId, error)
+            val entries = Rcbridge.rbDocListDir(parentDocumentId, null, error)
                 ?: throw error.toException("rbDocListDir")
 
             for (i in 0 until entries.size()) {
@@ -631,7 +675,7 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
         enforceNotBlocked(remote, config)
 
         val error = RbError()
-        val entry = Rcbridge.rbDocStat(documentId, error)
+        val entry = Rcbridge.rbDocStat(documentId, false, null, error)
             ?: throw error.toException("rbDocStat")
 
         return MatrixCursor(getDocumentProjection(projection)).apply {
@@ -751,8 +795,12 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
             fcntlMode = fcntlMode or OsConstants.O_APPEND
         }
 
+        val token = signal?.let { s ->
+            RbCancelToken().also { s.setOnCancelListener(it::cancel) }
+        }
         val error = RbError()
-        val handle = Rcbridge.rbDocOpen(documentId, fcntlMode.toLong(), FILE_PERMS.toLong(), error)
+        val handle = Rcbridge.rbDocOpen(
+            documentId, fcntlMode.toLong(), FILE_PERMS.toLong(), token, error)
             ?: throw error.toException("rbDocOpen")
 
         // We spawn a new thread for every opened file to handle I/O. When opening a file with
@@ -768,7 +816,9 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
         val ioHandler = Handler(ioThread.looper)
 
         val storageManager = context!!.getSystemService(StorageManager::class.java)
-        val proxyFd = ProxyFd(ioThread, documentId, handle, isWrite)
+        // The token stays valid after opening so that reads for a client that has cancelled its
+        // request don't keep the I/O thread blocked.
+        val proxyFd = ProxyFd(ioThread, documentId, handle, isWrite, token)
 
         try {
             return storageManager.openProxyFileDescriptor(pfdMode, proxyFd, ioHandler)
@@ -884,7 +934,7 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
                 }
             } else {
                 val flags = OsConstants.O_WRONLY or OsConstants.O_CREAT or OsConstants.O_EXCL
-                val handle = Rcbridge.rbDocOpen(it, flags.toLong(), FILE_PERMS.toLong(), error)
+                val handle = Rcbridge.rbDocOpen(it, flags.toLong(), FILE_PERMS.toLong(), null, error)
                     ?: throw error.toException("rbDocOpen")
 
                 if (!handle.close(error)) {
@@ -1013,7 +1063,7 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
         val targetBaseDocumentId = Rcbridge.rbPathJoin(targetParentDocumentId, baseName)
 
         return retryUnique(targetBaseDocumentId, ext, ConflictDetection.STAT) {
-            if (!Rcbridge.rbDocCopyOrMove(sourceDocumentId, it, copy, error)) {
+            if (!Rcbridge.rbDocCopyOrMove(sourceDocumentId, it, copy, null, error)) {
                 throw error.toException("rbDocCopyOrMove")
             }
         }.also {
@@ -1062,6 +1112,7 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
         private val documentId: String,
         private val handle: RbFile,
         private val isWrite: Boolean,
+        private val token: RbCancelToken?,
     ) : ProxyFileDescriptorCallback() {
         init {
             markUsed()
@@ -1095,7 +1146,7 @@ class RcloneProvider : DocumentsProvider(), SharedPreferences.OnSharedPreference
             }
 
             val error = RbError()
-            val n = handle.readAt(data, size.toLong(), offset, error)
+            val n = handle.readAt(data, size.toLong(), offset, token, error)
             if (n < 0) {
                 throw error.toException("RbFile.readAt")
             }
@@ -5,7 +5,12 @@
 
 package com.chiller3.rsaf.rclone
 
+import com.chiller3.rsaf.binding.rcbridge.RbError
+import com.chiller3.rsaf.binding.rcbridge.RbProvider
+import com.chiller3.rsaf.binding.rcbridge.RbProviderOption
+import com.chiller3.rsaf.binding.rcbridge.RbProviderOptionExample
 import com.chiller3.rsaf.binding.rcbridge.Rcbridge
+import com.chiller3.rsaf.extension.toException
 import org.json.JSONArray
 import org.json.JSONObject
 import java.io.IOException
@@ -18,6 +23,8 @@ object RcloneRpc {
     private const val CUSTOM_OPT_DYNAMIC_SHORTCUT = CUSTOM_OPT_PREFIX + "dynamic_shortcut"
     private const val CUSTOM_OPT_THUMBNAILS = CUSTOM_OPT_PREFIX + "thumbnails"
     private const val CUSTOM_OPT_REPORT_USAGE = CUSTOM_OPT_PREFIX + "report_usage"
+    private const val CUSTOM_OPT_BWLIMIT_FILE = CUSTOM_OPT_PREFIX + "bwlimit_file"
+    private const val CUSTOM_OPT_WIFI_ONLY = CUSTOM_OPT_PREFIX + "wifi_only"
     private const val CUSTOM_OPT_VFS_OPTIONS_PREFIX = CUSTOM_OPT_PREFIX + "vfs:"
 
     // Keep in sync with preferences_edit_remote.xml
@@ -34,6 +41,8 @@ object RcloneRpc {
                 || key == CUSTOM_OPT_DYNAMIC_SHORTCUT
                 || key == CUSTOM_OPT_THUMBNAILS
                 || key == CUSTOM_OPT_REPORT_USAGE
+                || key == CUSTOM_OPT_BWLIMIT_FILE
+                || key == CUSTOM_OPT_WIFI_ONLY
                 || key.startsWith(CUSTOM_OPT_VFS_OPTIONS_PREFIX)
 
     /**
@@ -101,181 +110,107 @@ object RcloneRpc {
      * This is computed once and cached forever.
      */
     val providers: Map<String, Provider> by lazy {
-        val output = invoke("config/providers", JSONObject())
-        val providersJson = output.getJSONArray("providers")
+        val providersList = Rcbridge.rbConfigProviders()
         val result = mutableMapOf<String, Provider>()
 
-        for (i in 0 until providersJson.length()) {
-            val provider = Provider(providersJson.getJSONObject(i))
+        for (i in 0 until providersList.size()) {
+            val provider = Provider(providersList.get(i))
             result[provider.name] = provider
         }
 
         result
     }
 
-    /**
-     * A fake interactive question for selecting a provider type.
-     *
-     * This is computed once and cached forever.
-     */
-    private val providerQuestion by lazy {
-        ProviderOption(JSONObject()
-            .put("Name", "type")
-            .put("FieldName", "")
-            // Same string as in `rclone config`
-            .put("Help", "Type of storage to configure.")
-            .put("DefaultStr", "")
-            .put("ValueStr", "")
-            .put("Examples", JSONArray().apply {
-                providers.entries.sortedBy { it.value.description }.forEach {
-                    put(JSONObject()
-                        .put("Value", it.key)
-                        .put("Help", it.value.description)
-                        .put("Provider", "")
-                    )
-                }
-            })
-            .put("Hide", 0)
-            .put("Required", true)
-            .put("IsPassword", false)
-            .put("NoPrefix", false)
-            .put("Advanced", false)
-            .put("Exclusive", true)
-            .put("Sensitive", false)
-            .put("Type", "string")
-        )
-    }
-
     /**
      * A fake set of [ProviderOptionExample]s used when the option type is a boolean and no existing
      * examples are provided.
      */
     private val booleanExamples by lazy {
         listOf(
-            ProviderOptionExample(JSONObject()
-                .put("Value", "false")
-                .put("Help", "false")
-                .put("Provider", "")
-            ),
-            ProviderOptionExample(JSONObject()
-                .put("Value", "true")
-                .put("Help", "true")
-                .put("Provider", "")
-            ),
+            ProviderOptionExample(value = "false", help = "false"),
+            ProviderOptionExample(value = "true", help = "true"),
         )
     }
 
-    /** Delete a remote from the configuration. */
-    fun deleteRemote(remote: String) {
-        invoke("config/delete", JSONObject(mapOf("name" to remote)))
-
-        // The RPC call will not clear out fs/vfs caches, so we'll do that manually here. Otherwise,
-        // we might reference stale data if the user creates a new remote with the same name.
-        Rcbridge.rbCacheClearRemote("$remote:", true)
-    }
-
     @Suppress("unused")
-    class CommandHelp(data: JSONObject) {
-        val name: String = data.getString("Name")
-        val short: String = data.getString("Short")
-        val long: String = data.getString("Long")
-        val opts: Map<String, String> = mutableMapOf<String, String>().apply {
-            data.optJSONObject("Opts")?.let { jsonOpts ->
-                for (key in jsonOpts.keys()) {
-                    put(key, jsonOpts.getString(key))
-                }
-            }
-        }
-    }
-
-    @Suppress("unused")
-    class MetadataHelp(data: JSONObject) {
-        val help: String = data.getString("Help")
-        val type: String = data.getString("Type")
-        val example: String = data.getString("Example")
-        val readOnly = data.getBoolean("ReadOnly")
-    }
-
-    @Suppress("unused")
-    class MetadataInfo(data: JSONObject) {
-        val system: Map<String, MetadataHelp> = mutableMapOf<String, MetadataHelp>().apply {
-            data.optJSONObject("System")?.let { jsonSystem ->
-                for (key in jsonSystem.keys()) {
-                    put(key, MetadataHelp(jsonSystem.getJSONObject(key)))
-                }
-            }
-        }
-        val help: String = data.getString("Help")
+    class Provider(
+        val name: String,
+        val description: String,
+        val prefix: String,
+        val options: List<ProviderOption>,
+        val aliases: List<String>,
+        val hide: Boolean,
+    ) {
+        constructor(provider: RbProvider) : this(
+            name = provider.name,
+            description = provider.description,
+            prefix = provider.prefix,
+            options = (0 until provider.options.size()).map {
+                ProviderOption(provider.options.get(it))
+            },
+            aliases = (0 until provider.aliases.size()).map {
+                provider.aliases.get(it)
+            },
+            hide = provider.hidden,
+        )
     }
 
     @Suppress("unused")
-    class Provider(data: JSONObject) {
-        val name: String = data.getString("Name")
-        val description: String = data.getString("Description")
-        val prefix: String = data.getString("Prefix")
-        val options: List<ProviderOption> = mutableListOf<ProviderOption>().apply {
-            val jsonOptions = data.getJSONArray("Options")
-
-            for (i in 0 until jsonOptions.length()) {
-                add(ProviderOption(jsonOptions.getJSONObject(i)))
-            }
-        }
-        val commandHelp: List<CommandHelp> = mutableListOf<CommandHelp>().apply {
-            data.optJSONArray("CommandHelp")?.let { jsonCommandHelp ->
-                for (i in 0 until jsonCommandHelp.length()) {
-                    add(CommandHelp(jsonCommandHelp.getJSONObject(i)))
-                }
-            }
-        }
-        val aliases: List<String> = mutableListOf<String>().apply {
-            data.optJSONArray("Aliases")?.let { jsonAliases ->
-                for (i in 0 until jsonAliases.length()) {
-                    add(jsonAliases.getString(i))
-                }
-            }
-        }
-        val hide = data.getBoolean("Hide")
-        val metadataInfo = data.optJSONObject("MetadataInfo")?.let { MetadataInfo(it) }
+    class ProviderOptionExample(
+        val value: String,
+        val help: String,
+        val provider: String = "",
+    ) {
+        constructor(example: RbProviderOptionExample) : this(
+            value = example.value,
+            help = example.help,
+            provider = example.provider,
+        )
     }
 
     @Suppress("unused")
-    class ProviderOptionExample(data: JSONObject) {
-        val value: String = data.getString("Value")
-        val help: String = data.getString("Help")
-        val provider: String = data.optString("Provider")
-    }
+    class ProviderOption(
+        val name: String,
+        val help: String,
+        val type: String,
+        val default: String = "",
+        val value: String = "",
+        examples: List<ProviderOptionExample> = emptyList(),
+        val provider: String = "",
+        val hide: Boolean = false,
+        val required: Boolean = false,
+        val isPassword: Boolean = false,
+        val advanced: Boolean = false,
+        exclusive: Boolean = false,
+        val sensitive: Boolean = false,
+    ) {
+        constructor(option: RbProviderOption) : this(
+            name = option.name,
+            help = option.help,
+            type = option.type,
+            default = option.default,
+            value = option.value,
+            examples = (0 until option.examples.size()).map {
+                ProviderOptionExample(option.examples.get(it))
+            },
+            provider = option.provider,
+            hide = option.hidden,
+            required = option.required,
+            isPassword = option.isPassword,
+            advanced = option.advanced,
+            exclusive = option.exclusive,
+            sensitive = option.sensitive,
+        )
 
-    @Suppress("unused")
-    class ProviderOption(data: JSONObject) {
-        val name: String = data.getString("Name")
-        val fieldName: String = data.getString("FieldName")
-        val help: String = data.getString("Help")
-        val groups: String = data.optString("Groups")
-        val provider: String = data.optString("Provider")
-        val default: String = data.getString("DefaultStr")
-        val value: String = data.getString("ValueStr")
-        val shortOpt: String = data.optString("ShortOpt")
-        val hide = data.getInt("Hide") != 0
-        val required = data.getBoolean("Required")
-        val isPassword = data.getBoolean("IsPassword")
-        val noPrefix = data.getBoolean("NoPrefix")
-        val advanced = data.getBoolean("Advanced")
-        val type: String = data.getString("Type")
-        val examples: List<ProviderOptionExample> = mutableListOf<ProviderOptionExample>().apply {
-            data.optJSONArray("Examples")?.let { jsonExamples ->
-                for (i in 0 until jsonExamples.length()) {
-                    add(ProviderOptionExample(jsonExamples.getJSONObject(i)))
-                }
-            }
+        val examples: List<ProviderOptionExample> = if (type == "bool" && examples.isEmpty()) {
             // Add fake options for better UX
-            if (type == "bool" && isEmpty()) {
-                addAll(booleanExamples)
-            }
+            booleanExamples
+        } else {
+            examples
         }
-        val exclusive = data.getBoolean("Exclusive") ||
+        val exclusive = exclusive ||
             // rclone doesn't mark boolean options as exclusive, so fake it ourselves for better UX
-            (type == "bool" && examples.map { it.value }.toSet() == setOf("false", "true"))
-        val sensitive = data.getBoolean("Sensitive")
+            (type == "bool" && this.examples.map { it.value }.toSet() == setOf("false", "true"))
 
         // To support our custom authorizer
         val isAuthorize = name == "config_token"
@@ -294,48 +229,26 @@ object RcloneRpc {
 
         init {
             // The UI assumes that there will never be 0 exclusive options
-            if (exclusive && examples.isEmpty()) {
-                throw IllegalStateException("Exclusive option, but no choices: $data")
+            if (this.exclusive && this.examples.isEmpty()) {
+                throw IllegalStateException("Exclusive option, but no choices: $name")
             }
         }
     }
 
-    class InteractiveConfiguration(private val remote: String) {
-        companion object {
-            private val SKIP_QUESTIONS = mapOf(
-                // We require either using Authorizer or having the user manually run rclone
-                // authorize. If true is selected, the question prints to stdout and blocks until
-                // the authorization is complete.
-                "config_is_local" to "false",
-            )
-        }
-
-        private data class Question(
-            // This is the answer submitted that resulted in obtaining this question.
-            val prevAnswer: String?,
-            val state: String?,
-            val error: String?,
-            val option: ProviderOption?,
-        )
+    /**
+     * Run rclone's configuration state machine for a remote one question at a time.
+     *
+     * If the remote does not exist, the first question asks for the remote type.
+     */
+    class InteractiveConfiguration(remote: String) {
+        private val session = run {
+            val error = RbError()
+            val session = Rcbridge.rbConfigSessionStart(remote, error)
+                ?: throw error.toException("rbConfigSessionStart")
 
-        private var questions = mutableListOf<Question>().apply {
-            if (remote !in remoteNames) {
-                add(Question(
-                    prevAnswer = null,
-                    state = null,
-                    error = null,
-                    option = providerQuestion,
-                ))
-            }
-        }
-        private var skipped = 0
+            RcloneConfig.notifyConfigChanged()
 
-        init {
-            // If we're not creating a new remote, we need to start the config process to get the
-            // first question
-            if (questions.isEmpty()) {
-                submit(null)
-            }
+            session
         }
 
         /**
@@ -348,10 +261,9 @@ object RcloneRpc {
          */
         val question: Pair<String?, ProviderOption>?
             get() {
-                val question = questions.lastOrNull() ?: return null
-                val option = question.option ?: return null
+                val question = session.question() ?: return null
 
-                return Pair(question.error, option)
+                return Pair(question.error.ifEmpty { null }, ProviderOption(question.option))
             }
 
         /**
@@ -361,103 +273,15 @@ object RcloneRpc {
          * remote will have already been created.
          */
         val hasPrevious: Boolean
-            get() = questions.size + skipped >= 2
-
-        private fun submitRaw(answer: String?) {
-            val question = questions.lastOrNull()
-            val isProviderQuestion = question?.option === providerQuestion
-
-            val input = JSONObject()
-                .put("name", remote)
-                // The parameters field is required to exist even in interactive mode.
-                .put("parameters", JSONObject())
-                .put("opt", JSONObject()
-                    .put("nonInteractive", true)
-                    .put("all", true)
-                    .put("obscure", true)
-                    .apply {
-                        question?.state?.let {
-                            put("state", it)
-                        }
-                        if (!isProviderQuestion) {
-                            val result = answer?.let {
-                                // The "obscure" flag is currently ignored for the "result" value:
-                                // https://github.com/rclone/rclone/issues/7069
-                                if (question?.option?.isPassword == true) {
-                                    obscure(it)
-                                } else {
-                                    it
-                                }
-                            }
-
-                            put("continue", true)
-                            put("result", result)
-                        }
-                    }
-                )
-                .apply {
-                    if (isProviderQuestion) {
-                        put("type", answer)
-                    }
-                }
-
-            val method = if (isProviderQuestion) {
-                "config/create"
-            } else {
-                "config/update"
-            }
-
-            val output = invoke(method, input)
-
-            var savedAnswer = answer
-
-            // We intentionally can't go back to the provider question because the remote will have
-            // been created at this point. Clear out the answer before persisting so that the state
-            // is just like if we were freshly editing an existing remote.
-            if (isProviderQuestion) {
-                questions.removeAt(questions.lastIndex)
-                savedAnswer = null
-            }
-
-            val error = output.optString("Error")
-            val newQuestion = Question(
-                prevAnswer = savedAnswer,
-                state = output.getString("State"),
-                error = if (error.isNullOrEmpty()) {
-                    null
-                } else {
-                    error
-                },
-                option = if (output.isNull("Option")) {
-                    null
-                } else {
-                    ProviderOption(output.getJSONObject("Option"))
-                },
-            )
-
-            // Avoid duplicates when retrying.
-            if (newQuestion.state != question?.state) {
-                questions.add(newQuestion)
-            } else {
-                questions[questions.size - 1] = newQuestion
-            }
-        }
+            get() = session.hasPrevious()
 
         fun submit(answer: String?) {
-            submitRaw(answer)
-
-            while (true) {
-                val name = questions.lastOrNull()?.option?.name ?: break
-                val skipAnswer = SKIP_QUESTIONS[name] ?: break
-
-                submitRaw(skipAnswer)
-                skipped += 1
+            val error = RbError()
+            if (!session.submit(answer ?: "", error)) {
+                throw error.toException("submit")
             }
-        }
 
-        private fun goBackRaw(): String? {
-            questions.removeAt(questions.lastIndex)
-            return questions.removeAt(questions.lastIndex).prevAnswer
+            RcloneConfig.notifyConfigChanged()
         }
 
         fun goBack() {
@@ -465,13 +289,12 @@ object RcloneRpc {
                 throw IllegalStateException("No previous state")
             }
 
-            submitRaw(goBackRaw())
-
-            while (questions.lastOrNull()?.option?.name in SKIP_QUESTIONS) {
-                val previousAnswer = goBackRaw()
-                skipped -= 1
-                submitRaw(previousAnswer)
+            val error = RbError()
+            if (!session.goBack(error)) {
+                throw error.toException("goBack")
             }
+
+            RcloneConfig.notifyConfigChanged()
         }
     }
 
@@ -513,19 +336,14 @@ object RcloneRpc {
         )
     }
 
-    /** Convert plain-text password to rclone-obscured password. */
-    private fun obscure(password: String): String {
-        val output = invoke("core/obscure", JSONObject(mapOf("clear" to password)))
-
-        return output.getString("obscured")
-    }
-
     data class RemoteConfig(
         val hardBlocked: Boolean? = null,
         val softBlocked: Boolean? = null,
         val dynamicShortcut: Boolean? = null,
         val thumbnails: Boolean? = null,
         val reportUsage: Boolean? = null,
+        val bwLimitFile: String? = null,
+        val wifiOnly: Boolean? = null,
         val vfsOptions: Map<String, String>? = null,
     ) {
         constructor(config: Map<String, String>) : this(
@@ -534,6 +352,8 @@ object RcloneRpc {
             dynamicShortcut = config[CUSTOM_OPT_DYNAMIC_SHORTCUT]?.toBooleanStrictOrNull(),
             thumbnails = config[CUSTOM_OPT_THUMBNAILS]?.toBooleanStrictOrNull(),
             reportUsage = config[CUSTOM_OPT_REPORT_USAGE]?.toBooleanStrictOrNull(),
+            bwLimitFile = config[CUSTOM_OPT_BWLIMIT_FILE],
+            wifiOnly = config[CUSTOM_OPT_WIFI_ONLY]?.toBooleanStrictOrNull(),
             vfsOptions = config
                 .asSequence()
                 .filter { it.key.startsWith(CUSTOM_OPT_VFS_OPTIONS_PREFIX) }
@@ -547,6 +367,8 @@ object RcloneRpc {
             dynamicShortcut?.let { put(CUSTOM_OPT_DYNAMIC_SHORTCUT, it.toString()) }
             thumbnails?.let { put(CUSTOM_OPT_THUMBNAILS, it.toString()) }
             reportUsage?.let { put(CUSTOM_OPT_REPORT_USAGE, it.toString()) }
+            bwLimitFile?.let { put(CUSTOM_OPT_BWLIMIT_FILE, it) }
+            wifiOnly?.let { put(CUSTOM_OPT_WIFI_ONLY, it.toString()) }
             vfsOptions?.let { it.mapKeysTo(this) { e -> CUSTOM_OPT_VFS_OPTIONS_PREFIX + e.key } }
         }
 
@@ -556,6 +378,8 @@ object RcloneRpc {
             key == CUSTOM_OPT_DYNAMIC_SHORTCUT -> dynamicShortcut != null
             key == CUSTOM_OPT_THUMBNAILS -> thumbnails != null
             key == CUSTOM_OPT_REPORT_USAGE -> reportUsage != null
+            key == CUSTOM_OPT_BWLIMIT_FILE -> bwLimitFile != null
+            key == CUSTOM_OPT_WIFI_ONLY -> wifiOnly != null
             key.startsWith(CUSTOM_OPT_VFS_OPTIONS_PREFIX) -> vfsOptions != null
             else -> false
         }
@@ -599,22 +423,19 @@ object RcloneRpc {
 
     /** Get the filesystem usage. */
     fun getUsage(remote: String): Usage {
-        val output = invoke("operations/about", JSONObject().put("fs", remote))
+        val error = RbError()
+        val about = Rcbridge.rbRemoteAbout(remote, null, error)
+            ?: throw error.toException("rbRemoteAbout")
 
-        fun getOptLong(name: String) =
-            if (output.isNull(name)) {
-                null
-            } else {
-                output.getLong(name)
-            }
+        fun Long.takeIfKnown() = takeIf { it >= 0 }
 
         return Usage(
-            getOptLong("total"),
-            getOptLong("used"),
-            getOptLong("trashed"),
-            getOptLong("other"),
-            getOptLong("free"),
-            getOptLong("objects"),
+            about.total.takeIfKnown(),
+            about.used.takeIfKnown(),
+            about.trashed.takeIfKnown(),
+            about.other.takeIfKnown(),
+            about.free.takeIfKnown(),
+            about.objects.takeIfKnown(),
         )
     }
 
@@ -653,18 +474,4 @@ object RcloneRpc {
 
         return VfsQueueStats(inProgress, pending)
     }
-
-    fun authorizeUrl(): String? {
-        val output = invoke("config/oauthstatus", JSONObject())
-
-        return if (output.getString("status") == "running") {
-            output.getString("authUrl")
-        } else {
-            null
-        }
-    }
-
-    fun authorizeCancel() {
-        invoke("config/oauthstop", JSONObject())
-    }
 }
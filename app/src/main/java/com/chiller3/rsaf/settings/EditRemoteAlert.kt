@@ -10,6 +10,10 @@ sealed interface EditRemoteAlert {
 
     data class RemoteEditSucceeded(val remote: String) : EditRemoteAlert
 
+    data class RemoteTestSucceeded(val remote: String, val millis: Long) : EditRemoteAlert
+
+    data class RemoteTestFailed(val remote: String, val error: String) : EditRemoteAlert
+
     data class RemoteDeleteFailed(val remote: String, val error: String) : EditRemoteAlert
 
     data class RemoteRenameFailed(
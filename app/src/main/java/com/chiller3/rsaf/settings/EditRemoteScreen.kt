@@ -83,6 +83,10 @@ fun EditRemoteScreen(
                         resources.getString(R.string.alert_list_remotes_failure)
                     is EditRemoteAlert.RemoteEditSucceeded ->
                         resources.getString(R.string.alert_edit_remote_success, alert.remote)
+                    is EditRemoteAlert.RemoteTestSucceeded ->
+                        resources.getString(R.string.alert_test_remote_success, alert.remote, alert.millis)
+                    is EditRemoteAlert.RemoteTestFailed ->
+                        resources.getString(R.string.alert_test_remote_failure, alert.remote)
                     is EditRemoteAlert.RemoteDeleteFailed ->
                         resources.getString(R.string.alert_delete_remote_failure, alert.remote)
                     is EditRemoteAlert.RemoteRenameFailed ->
@@ -97,6 +101,8 @@ fun EditRemoteScreen(
                 val details = when (alert) {
                     is EditRemoteAlert.ListRemotesFailed -> alert.error
                     is EditRemoteAlert.RemoteEditSucceeded -> null
+                    is EditRemoteAlert.RemoteTestSucceeded -> null
+                    is EditRemoteAlert.RemoteTestFailed -> alert.error
                     is EditRemoteAlert.RemoteDeleteFailed -> alert.error
                     is EditRemoteAlert.RemoteRenameFailed -> alert.error
                     is EditRemoteAlert.RemoteDuplicateFailed -> alert.error
@@ -142,6 +148,9 @@ fun EditRemoteScreen(
                     InteractiveConfigurationActivity.createIntent(context, remote, false)
                 )
             },
+            onRemoteTest = {
+                viewModel.testRemote()
+            },
             onRemoteRename = { name ->
                 viewModel.renameRemote(name)
             },
@@ -204,6 +213,7 @@ private fun EditRemoteContent(
     requireAuth: Boolean,
     onRemoteOpen: () -> Unit,
     onRemoteConfigure: () -> Unit,
+    onRemoteTest: () -> Unit,
     onRemoteRename: (String) -> Unit,
     onRemoteDuplicate: (String) -> Unit,
     onRemoteDelete: () -> Unit,
@@ -252,6 +262,16 @@ private fun EditRemoteContent(
             )
         }
 
+        item(key = "test_remote") {
+            Preference(
+                onClick = onRemoteTest,
+                shapes = BetterSegmentedShapes.middle(),
+                title = { Text(text = stringResource(R.string.pref_edit_remote_test_name)) },
+                summary = { Text(text = stringResource(R.string.pref_edit_remote_test_desc)) },
+                modifier = Modifier.animateItem(),
+            )
+        }
+
         item(key = "rename_remote") {
             Preference(
                 onClick = {
@@ -470,6 +490,7 @@ private fun PreviewEditRemoteScreen() {
                 requireAuth = false,
                 onRemoteOpen = {},
                 onRemoteConfigure = {},
+                onRemoteTest = {},
                 onRemoteRename = {},
                 onRemoteDuplicate = {},
                 onRemoteDelete = {},
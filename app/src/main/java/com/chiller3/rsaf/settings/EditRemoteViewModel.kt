@@ -235,9 +235,10 @@ class EditRemoteViewModel : ViewModel() {
 
             try {
                 withContext(Dispatchers.IO) {
-                    RcloneConfig.copyRemote(remote, newRemote)
                     if (delete) {
-                        RcloneRpc.deleteRemote(remote)
+                        RcloneConfig.renameRemote(remote, newRemote)
+                    } else {
+                        RcloneConfig.copyRemote(remote, newRemote)
                     }
                 }
                 _activityActions.update {
@@ -262,11 +263,40 @@ class EditRemoteViewModel : ViewModel() {
         copyRemote(newRemote, false)
     }
 
+    fun testRemote() {
+        launchOperation { remote ->
+            try {
+                val result = withContext(Dispatchers.IO) {
+                    val error = RbError()
+                    Rcbridge.rbRemoteTest("$remote:", null, null, error)
+                        ?: throw error.toException("rbRemoteTest")
+                }
+
+                val alert = if (result.success) {
+                    EditRemoteAlert.RemoteTestSucceeded(
+                        remote,
+                        result.createMillis + result.listMillis,
+                    )
+                } else {
+                    Log.w(TAG, "Remote $remote failed test at step ${result.failedStep}")
+                    EditRemoteAlert.RemoteTestFailed(remote, result.error!!.msg)
+                }
+
+                _alerts.update { it + alert }
+            } catch (e: Exception) {
+                Log.e(TAG, "Failed to test remote $remote", e)
+                _alerts.update {
+                    it + EditRemoteAlert.RemoteTestFailed(remote, e.toSingleLineString())
+                }
+            }
+        }
+    }
+
     fun deleteRemote() {
         launchOperation { remote ->
             try {
                 withContext(Dispatchers.IO) {
-                    RcloneRpc.deleteRemote(remote)
+                    RcloneConfig.deleteRemote(remote)
                 }
                 _activityActions.update { it.copy(refreshRoots = true, finish = true) }
             } catch (e: Exception) {
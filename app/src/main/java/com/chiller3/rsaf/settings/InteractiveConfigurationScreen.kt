@@ -80,8 +80,6 @@ import com.chiller3.rsaf.ui.betterSegmentedShapes
 import com.chiller3.rsaf.ui.copy
 import com.chiller3.rsaf.ui.theme.AppTheme
 import com.chiller3.rsaf.ui.theme.Icons
-import org.json.JSONArray
-import org.json.JSONObject
 
 private const val TAG = "InteractiveConfigurationScreen"
 
@@ -482,34 +480,14 @@ private fun NavigationButtons(
 private fun PreviewQuestionArbitrary() {
     val remote = "test"
     val option = RcloneRpc.ProviderOption(
-        JSONObject()
-            .put("Name", "test")
-            .put("FieldName", "")
-            .put("Help", "This is an open ended question with a https://localhost link.")
-            .put("DefaultStr", "")
-            .put("ValueStr", "")
-            .put("Examples", JSONArray().apply {
-                put(
-                    JSONObject()
-                        .put("Value", "a")
-                        .put("Help", "First option")
-                        .put("Provider", "")
-                )
-                put(
-                    JSONObject()
-                        .put("Value", "b")
-                        .put("Help", "Second option")
-                        .put("Provider", "")
-                )
-            })
-            .put("Hide", 0)
-            .put("Required", true)
-            .put("IsPassword", false)
-            .put("NoPrefix", false)
-            .put("Advanced", false)
-            .put("Exclusive", false)
-            .put("Sensitive", false)
-            .put("Type", "string")
+        name = "test",
+        help = "This is an open ended question with a https://localhost link.",
+        type = "string",
+        examples = listOf(
+            RcloneRpc.ProviderOptionExample(value = "a", help = "First option"),
+            RcloneRpc.ProviderOptionExample(value = "b", help = "Second option"),
+        ),
+        required = true,
     )
 
     AppTheme {
@@ -544,34 +522,16 @@ private fun PreviewQuestionArbitrary() {
 private fun PreviewQuestionExclusive() {
     val remote = "test"
     val option = RcloneRpc.ProviderOption(
-        JSONObject()
-            .put("Name", "test")
-            .put("FieldName", "")
-            .put("Help", "This is a question with fixed choices.")
-            .put("DefaultStr", "b")
-            .put("ValueStr", "")
-            .put("Examples", JSONArray().apply {
-                put(
-                    JSONObject()
-                        .put("Value", "a")
-                        .put("Help", "First option")
-                        .put("Provider", "")
-                )
-                put(
-                    JSONObject()
-                        .put("Value", "b")
-                        .put("Help", "Second option")
-                        .put("Provider", "")
-                )
-            })
-            .put("Hide", 0)
-            .put("Required", true)
-            .put("IsPassword", false)
-            .put("NoPrefix", false)
-            .put("Advanced", false)
-            .put("Exclusive", true)
-            .put("Sensitive", false)
-            .put("Type", "string")
+        name = "test",
+        help = "This is a question with fixed choices.",
+        type = "string",
+        default = "b",
+        examples = listOf(
+            RcloneRpc.ProviderOptionExample(value = "a", help = "First option"),
+            RcloneRpc.ProviderOptionExample(value = "b", help = "Second option"),
+        ),
+        required = true,
+        exclusive = true,
     )
 
     AppTheme {
@@ -606,21 +566,12 @@ private fun PreviewQuestionExclusive() {
 private fun PreviewQuestionPassword() {
     val remote = "test"
     val option = RcloneRpc.ProviderOption(
-        JSONObject()
-            .put("Name", "test")
-            .put("FieldName", "")
-            .put("Help", "This is a question for a password.")
-            .put("DefaultStr", "hunter2")
-            .put("ValueStr", "")
-            .put("Examples", JSONArray())
-            .put("Hide", 0)
-            .put("Required", true)
-            .put("IsPassword", true)
-            .put("NoPrefix", false)
-            .put("Advanced", false)
-            .put("Exclusive", false)
-            .put("Sensitive", false)
-            .put("Type", "string")
+        name = "test",
+        help = "This is a question for a password.",
+        type = "string",
+        default = "hunter2",
+        required = true,
+        isPassword = true,
     )
 
     AppTheme {
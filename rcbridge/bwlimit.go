@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	goSync "sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/fspath"
+)
+
+const (
+	// Per-remote override for the per-file bandwidth limit. The total
+	// bandwidth limit cannot be overridden per remote because rclone has a
+	// single global token bucket.
+	rsafBwLimitFile = "rsaf:bwlimit_file"
+)
+
+var (
+	// Protects the bandwidth limit state below. rclone reads the config's
+	// bandwidth limit fields without locking, so they are never modified after
+	// the config is in use.
+	bwLimitLock goSync.Mutex
+	// The per-file bandwidth limit for remotes without an override.
+	bwLimitFile fs.BwTimetable
+	// Stops the goroutine that follows the current timetable's schedule.
+	bwLimitCancel context.CancelFunc
+	// The contexts of the VFS instances, keyed by remote.
+	bwLimitContexts = make(map[string]*bwLimitContext)
+)
+
+// A context whose config can be replaced while it is in use. rclone gets the
+// config from the context whenever it starts a transfer, so this lets a VFS
+// instance pick up a new per-file bandwidth limit without being restarted.
+// Transfers that are already in progress keep their limit.
+type bwLimitContext struct {
+	context.Context
+	// A context that only holds the current config. The config is never
+	// modified after it is stored.
+	configCtx atomic.Pointer[context.Context]
+}
+
+func newBwLimitContext(timetable fs.BwTimetable) *bwLimitContext {
+	ctx := &bwLimitContext{Context: context.Background()}
+	ctx.setBwLimitFile(timetable)
+
+	return ctx
+}
+
+// Replace the config with a copy of the global config that has the specified
+// per-file bandwidth limit.
+func (ctx *bwLimitContext) setBwLimitFile(timetable fs.BwTimetable) {
+	configCtx, ci := fs.AddConfig(context.Background())
+	ci.BwLimitFile = timetable
+
+	ctx.configCtx.Store(&configCtx)
+}
+
+func (ctx *bwLimitContext) Value(key any) any {
+	if value := (*ctx.configCtx.Load()).Value(key); value != nil {
+		return value
+	}
+
+	return ctx.Context.Value(key)
+}
+
+// Parse an rclone bandwidth timetable. An empty string means unlimited.
+func parseBwTimetable(s string) (fs.BwTimetable, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var timetable fs.BwTimetable
+
+	err := timetable.Set(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return timetable, nil
+}
+
+// Update the global token bucket to match the timetable's current time slot
+// every minute until the context is cancelled.
+func followBwTimetable(ctx context.Context, timetable fs.BwTimetable, current fs.BwPair) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		limit := timetable.LimitAt(time.Now()).Bandwidth
+		if limit != current {
+			fs.Logf(nil, "Scheduled bandwidth change")
+			accounting.TokenBucket.SetBwLimit(limit)
+			current = limit
+		}
+	}
+}
+
+// Get the remote's per-file bandwidth limit override and whether it is set.
+func getBwLimitFileOverride(remote string) (fs.BwTimetable, bool, error) {
+	parsed, err := fspath.Parse(remote)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, found := config.Data().GetValue(parsed.Name, rsafBwLimitFile)
+	if !found {
+		return nil, false, nil
+	}
+
+	timetable, err := parseBwTimetable(value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return timetable, true, nil
+}
+
+// Get the remote's per-file bandwidth limit override or the global value if
+// there is none.
+//
+// Must be called with bwLimitLock held.
+func getRemoteBwLimitFile(remote string) (fs.BwTimetable, error) {
+	timetable, found, err := getBwLimitFileOverride(remote)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return bwLimitFile, nil
+	}
+
+	return timetable, nil
+}
+
+// Create the context for a new VFS instance. The VFS gets its own copy of the
+// global config so that the remote's per-file bandwidth limit override does
+// not affect other remotes.
+func newVfsContext(remote string) (context.Context, error) {
+	bwLimitLock.Lock()
+	defer bwLimitLock.Unlock()
+
+	timetable, err := getRemoteBwLimitFile(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := newBwLimitContext(timetable)
+	bwLimitContexts[remote] = ctx
+
+	return ctx, nil
+}
+
+// Add the per-file bandwidth limit for a copy or move between two documents to
+// the context. The target remote's override takes priority over the source
+// remote's.
+func withBwLimitFile(ctx context.Context, sourceDoc string, targetDoc string) (context.Context, error) {
+	bwLimitLock.Lock()
+	defer bwLimitLock.Unlock()
+
+	timetable := bwLimitFile
+
+	for _, doc := range []string{targetDoc, sourceDoc} {
+		remote, _, err := fspath.SplitFs(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		override, found, err := getBwLimitFileOverride(remote)
+		if err != nil {
+			return nil, err
+		} else if found {
+			timetable = override
+			break
+		}
+	}
+
+	ctx, ci := fs.AddConfig(ctx)
+	ci.BwLimitFile = timetable
+
+	return ctx, nil
+}
+
+// Set the global bandwidth limits. Both values use rclone's --bwlimit
+// timetable syntax, like "10M", "1M:off" for separate upload and download
+// limits, or "08:00,512k 19:00,off" for a schedule. An empty string means
+// unlimited. The rate limit applies to all transfers combined and takes effect
+// immediately, including for transfers that are in progress. The file rate
+// limit applies to each file individually and takes effect for transfers that
+// start afterwards, including those of existing VFS instances.
+//
+// Remotes can override the file rate limit with the rsaf:bwlimit_file config
+// option. Copies and moves read it when they start. This function must be
+// called again after the option is changed for existing VFS instances to pick
+// up the new value.
+func RbSetBandwidthLimit(rate string, fileRate string, errOut *RbError) bool {
+	rateTimetable, err := parseBwTimetable(rate)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return false
+	}
+
+	fileRateTimetable, err := parseBwTimetable(fileRate)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return false
+	}
+
+	bwLimitLock.Lock()
+	defer bwLimitLock.Unlock()
+
+	bwLimitFile = fileRateTimetable
+
+	for remote, ctx := range bwLimitContexts {
+		timetable, err := getRemoteBwLimitFile(remote)
+		if err != nil {
+			fs.Logf(remote, "Failed to apply bandwidth limit override: %v", err)
+			continue
+		}
+
+		ctx.setBwLimitFile(timetable)
+	}
+
+	if bwLimitCancel != nil {
+		bwLimitCancel()
+		bwLimitCancel = nil
+	}
+
+	current := rateTimetable.LimitAt(time.Now()).Bandwidth
+	accounting.TokenBucket.SetBwLimit(current)
+
+	// rclone's own ticker only exists if there was a timetable at startup and
+	// it follows the global config's timetable, which is never modified.
+	if len(rateTimetable) > 1 {
+		var ctx context.Context
+		ctx, bwLimitCancel = context.WithCancel(context.Background())
+
+		go followBwTimetable(ctx, rateTimetable, current)
+	}
+
+	return true
+}
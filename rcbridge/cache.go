@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	goSync "sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+)
+
+const (
+	// The cache max age while purging, which makes the cache cleaner remove
+	// every file that it is allowed to.
+	cachePurgeMaxAge = fs.Duration(1)
+	// How long to wait after the cache cleaner's poll interval for it to
+	// finish its pass.
+	cachePurgeGracePeriod = 2 * time.Second
+	// How often to check whether the cache cleaner has removed everything.
+	cachePurgeCheckInterval = 250 * time.Millisecond
+)
+
+var (
+	openFilesLock goSync.Mutex
+	// Number of open RbFile handles for each VFS instance. rclone does not
+	// expose the number of open files in the cache.
+	openFiles = make(map[*vfs.VFS]int)
+	// Number of in-progress RbVfsCachePurge() calls for each VFS instance. This
+	// is only accessed while holding networkLock.
+	cachePurges = make(map[*vfs.VFS]int)
+)
+
+func addOpenFile(v *vfs.VFS, delta int) {
+	openFilesLock.Lock()
+	defer openFilesLock.Unlock()
+
+	openFiles[v] += delta
+	if openFiles[v] <= 0 {
+		delete(openFiles, v)
+	}
+}
+
+func getOpenFiles(v *vfs.VFS) int {
+	openFilesLock.Lock()
+	defer openFilesLock.Unlock()
+
+	return openFiles[v]
+}
+
+// Get the VFS's disk cache statistics or nil if caching is disabled.
+func getVfsCacheStats(v *vfs.VFS) rc.Params {
+	stats, _ := v.Stats()["diskCache"].(rc.Params)
+	return stats
+}
+
+type RbVfsCacheStatsResult struct {
+	// Disk space used by cached file data.
+	BytesUsed int64
+	// Number of files in the cache.
+	Files int
+	// Number of cached files that are currently open.
+	OpenFiles int
+	// Number of cached files with changes that are waiting to be uploaded or
+	// are being uploaded.
+	DirtyFiles int
+}
+
+// Get statistics about the remote's VFS disk cache. All values are 0 if
+// caching is disabled or the VFS has not been created.
+func RbVfsCacheStats(remote string, errOut *RbError) *RbVfsCacheStatsResult {
+	result := RbVfsCacheStatsResult{}
+
+	v, err := getExistingVfs(remote)
+	if err == syscall.ENOENT {
+		return &result
+	} else if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	stats := getVfsCacheStats(v)
+	if stats == nil {
+		return &result
+	}
+
+	// None of these can fail unless rclone changes the types.
+	result.BytesUsed, _ = stats.GetInt64("bytesUsed")
+	files, _ := stats.GetInt64("files")
+	uploadsInProgress, _ := stats.GetInt64("uploadsInProgress")
+	uploadsQueued, _ := stats.GetInt64("uploadsQueued")
+
+	result.Files = int(files)
+	result.OpenFiles = getOpenFiles(v)
+	result.DirtyFiles = int(uploadsInProgress + uploadsQueued)
+
+	return &result
+}
+
+// Set the VFS's cache max age based on whether its cache is being purged or
+// its cleanup is paused because the network can't be used. Purging takes
+// priority. rclone's cache cleaner reads the option at the start of every pass,
+// so this takes effect without restarting the VFS.
+//
+// Must be called with networkLock held.
+func applyCacheMaxAge(state *vfsState, v *vfs.VFS) {
+	maxAge := state.cacheMaxAge
+	if cachePurges[v] > 0 {
+		maxAge = cachePurgeMaxAge
+	} else if state.pausedVfs == v {
+		maxAge = fs.DurationOff
+	}
+
+	atomic.StoreInt64((*int64)(&v.Opt.CacheMaxAge), int64(maxAge))
+}
+
+// Wait for the VFS cache cleaner to complete a pass. rclone has no way to run
+// the cleaner on demand, so this waits for one poll interval, returning early
+// if only files with changes that have not been uploaded are left.
+func waitForCacheCleaner(v *vfs.VFS, token *RbCancelToken) error {
+	ctx := tokenContext(token)
+	timer := time.NewTimer(time.Duration(v.Opt.CachePollInterval) + cachePurgeGracePeriod)
+	defer timer.Stop()
+	ticker := time.NewTicker(cachePurgeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		stats := getVfsCacheStats(v)
+		if stats == nil {
+			return nil
+		}
+
+		files, _ := stats.GetInt64("files")
+		uploadsInProgress, _ := stats.GetInt64("uploadsInProgress")
+		uploadsQueued, _ := stats.GetInt64("uploadsQueued")
+
+		if files <= uploadsInProgress+uploadsQueued {
+			return nil
+		}
+	}
+}
+
+// Remove cached data from the remote's VFS disk cache while the VFS keeps
+// running. This does nothing if caching is disabled or the VFS has not been
+// created.
+//
+// The cache's own cleaner does the removal, so files that are open or have
+// changes that have not been uploaded are always kept. Because of this,
+// onlyClean must be true and false fails with EOPNOTSUPP. This blocks for up to
+// the VFS cache's poll interval. Fails with EOPNOTSUPP if the cleaner is
+// disabled.
+func RbVfsCachePurge(remote string, onlyClean bool, token *RbCancelToken, errOut *RbError) bool {
+	if !onlyClean {
+		assignError(errOut, syscall.EOPNOTSUPP, syscall.EOPNOTSUPP)
+		return false
+	}
+
+	vfsLock.Lock()
+	state, ok := vfsStates[remote]
+	vfsLock.Unlock()
+
+	if !ok {
+		return true
+	}
+
+	v := state.v.Load()
+	if v == nil || getVfsCacheStats(v) == nil {
+		return true
+	} else if v.Opt.CachePollInterval <= 0 {
+		assignError(errOut, syscall.EOPNOTSUPP, syscall.EOPNOTSUPP)
+		return false
+	}
+
+	fs.Logf(remote, "Purging VFS cache")
+
+	networkLock.Lock()
+	cachePurges[v]++
+	applyCacheMaxAge(state, v)
+	networkLock.Unlock()
+
+	defer func() {
+		networkLock.Lock()
+		cachePurges[v]--
+		if cachePurges[v] <= 0 {
+			delete(cachePurges, v)
+		}
+		applyCacheMaxAge(state, v)
+		networkLock.Unlock()
+	}()
+
+	err := waitForCacheCleaner(v, token)
+	if err != nil {
+		assignError(errOut, err, syscall.ECANCELED)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	goSync "sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fspath"
+)
+
+// Interface to be implemented by the host to receive notifications about
+// documents that changed outside of rcbridge's control or whose cached state
+// was invalidated. OnChange() is called from a background thread and must not
+// block for long.
+type RbChangeListener interface {
+	// Called when a document changes. If isDir is true, the document is a
+	// directory and its children may have changed too. The document may no
+	// longer exist.
+	OnChange(doc string, isDir bool)
+}
+
+type changeNotifyFunc func(context.Context, func(string, fs.EntryType), <-chan time.Duration)
+
+var (
+	changeListenerLock goSync.RWMutex
+	changeListener     RbChangeListener
+
+	// The original ChangeNotify of each backend instance that has been hooked.
+	// This is keyed by the features pointer, which is unique per backend
+	// instance.
+	changeNotifyHookedLock goSync.Mutex
+	changeNotifyHooked     = make(map[*fs.Features]changeNotifyFunc)
+)
+
+// Register a listener to receive change notifications for all remotes. Only
+// one listener can be registered. Pass nil to unregister the existing listener.
+// Changes reported by the backend are only received if the backend supports
+// ChangeNotify, like Drive and OneDrive, and only while the remote's VFS is
+// active.
+func RbSetChangeListener(listener RbChangeListener) {
+	changeListenerLock.Lock()
+	defer changeListenerLock.Unlock()
+
+	changeListener = listener
+}
+
+// Report that a path within the remote changed to the registered listener, if
+// any.
+func notifyChange(remote string, path string, entryType fs.EntryType) {
+	changeListenerLock.RLock()
+	listener := changeListener
+	changeListenerLock.RUnlock()
+
+	if listener != nil {
+		listener.OnChange(fspath.JoinRootPath(remote, path), entryType == fs.EntryDirectory)
+	}
+}
+
+// Wrap the backend's ChangeNotify so that the changes received by the VFS are
+// also reported to the host. This must be called before the VFS is created
+// because vfs.New() is where ChangeNotify is started.
+func hookChangeNotify(remote string, f fs.Fs) {
+	changeNotifyHookedLock.Lock()
+	defer changeNotifyHookedLock.Unlock()
+
+	features := f.Features()
+
+	doChangeNotify := features.ChangeNotify
+	if doChangeNotify == nil {
+		return
+	} else if _, ok := changeNotifyHooked[features]; ok {
+		return
+	}
+
+	features.ChangeNotify = func(ctx context.Context, notify func(string, fs.EntryType), pollInterval <-chan time.Duration) {
+		doChangeNotify(ctx, func(path string, entryType fs.EntryType) {
+			notify(path, entryType)
+			notifyChange(remote, path, entryType)
+		}, pollInterval)
+	}
+
+	changeNotifyHooked[features] = doChangeNotify
+}
+
+// Restore the backend's original ChangeNotify. This must be called when the VFS
+// is shut down because the backend instance may still be in the cache and be
+// reused for the next VFS.
+func unhookChangeNotify(f fs.Fs) {
+	changeNotifyHookedLock.Lock()
+	defer changeNotifyHookedLock.Unlock()
+
+	features := f.Features()
+
+	doChangeNotify, ok := changeNotifyHooked[features]
+	if !ok {
+		return
+	}
+
+	features.ChangeNotify = doChangeNotify
+	delete(changeNotifyHooked, features)
+}
@@ -0,0 +1,627 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	ioFs "io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/unknwon/goconfig"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Get the VFS cache data and metadata directories for all instances of a
+// remote. The VFS creates one subdirectory per remote root underneath these.
+func getVfsCacheDirs(name string) []string {
+	osName := encoder.OS.FromStandardName(name)
+
+	return []string{
+		filepath.Join(config.GetCacheDir(), "vfs", osName),
+		filepath.Join(config.GetCacheDir(), "vfsMeta", osName),
+	}
+}
+
+// Shut down the VFS instances for every remote backed by the config section and
+// clear the cached fs instances.
+func shutdownSection(name string, deleteCacheDir bool) {
+	for remote, state := range getVfsStates() {
+		parsed, err := fspath.Parse(remote)
+		if err != nil || parsed.Name != name {
+			continue
+		}
+
+		state.shutdown(remote, deleteCacheDir)
+	}
+
+	cache.ClearConfig(name)
+}
+
+// Move the VFS cache directories from one remote to another. If a move fails,
+// the directories that were already moved are moved back.
+func moveVfsCacheDirs(oldName string, newName string) error {
+	oldDirs := getVfsCacheDirs(oldName)
+	newDirs := getVfsCacheDirs(newName)
+
+	for i := range oldDirs {
+		err := os.Rename(oldDirs[i], newDirs[i])
+		if err == nil || errors.Is(err, ioFs.ErrNotExist) {
+			continue
+		}
+
+		for j := range i {
+			if err := os.Rename(newDirs[j], oldDirs[j]); err != nil && !errors.Is(err, ioFs.ErrNotExist) {
+				fs.Logf(nil, "Failed to move back VFS cache directory: %q: %v", newDirs[j], err)
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Get a copy of all key/value pairs in a config section in order.
+func getSection(name string) []RbKeyValue {
+	section := []RbKeyValue{}
+
+	for _, key := range config.Data().GetKeyList(name) {
+		value, found := config.Data().GetValue(name, key)
+		if found {
+			section = append(section, RbKeyValue{Key: key, Value: value})
+		}
+	}
+
+	return section
+}
+
+// Get a copy of all config sections.
+func getSections() map[string][]RbKeyValue {
+	sections := map[string][]RbKeyValue{}
+
+	for _, name := range config.Data().GetSectionList() {
+		sections[name] = getSection(name)
+	}
+
+	return sections
+}
+
+// Check whether a config value refers to a remote, like the remote option of
+// alias or crypt remotes, or the upstreams option of union remotes. This is a
+// heuristic that splits the value on whitespace. It misses references that are
+// quoted, like "name:dir with spaces", and references inside connection
+// strings with other parameters before them, like
+// :crypt,password=x,remote=name:.
+func referencesRemote(value string, name string) bool {
+	for _, field := range strings.Fields(value) {
+		// combine remotes use "dir=remote:path".
+		_, field, _ = strings.Cut(field, "=")
+
+		if strings.HasPrefix(field, name+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the names of the sections that differ between the two snapshots, sorted
+// by name. Sections that wrap a changed section are also considered changed
+// because their instances hold on to the wrapped remote's instance.
+func diffSections(oldSections map[string][]RbKeyValue, newSections map[string][]RbKeyValue) []string {
+	changed := map[string]bool{}
+
+	for name, oldSection := range oldSections {
+		newSection, ok := newSections[name]
+		if !ok || !slices.Equal(oldSection, newSection) {
+			changed[name] = true
+		}
+	}
+	for name := range newSections {
+		if _, ok := oldSections[name]; !ok {
+			changed[name] = true
+		}
+	}
+
+	for found := true; found; {
+		found = false
+
+		for name, section := range newSections {
+			if changed[name] {
+				continue
+			}
+
+			if slices.ContainsFunc(section, func(kv RbKeyValue) bool {
+				for other := range changed {
+					if referencesRemote(kv.Value, other) {
+						return true
+					}
+				}
+				return false
+			}) {
+				changed[name] = true
+				found = true
+			}
+		}
+	}
+
+	return slices.Sorted(maps.Keys(changed))
+}
+
+// Replace a config section with the specified key/value pairs.
+func setSection(name string, section []RbKeyValue) {
+	config.Data().DeleteSection(name)
+
+	for _, kv := range section {
+		config.Data().SetValue(name, kv.Key, kv.Value)
+	}
+}
+
+// Rename a remote's config section, including its custom RSAF options. The
+// remote's VFS instances are shut down and its VFS cache directories are moved
+// to the new name so that pending uploads are not lost. The config is saved
+// only if everything else succeeds. Fails with ENOENT if the old remote does
+// not exist and EEXIST if the new remote already exists.
+func RbConfigRenameSection(oldName string, newName string, errOut *RbError) bool {
+	for _, name := range []string{oldName, newName} {
+		if err := fspath.CheckConfigName(name); err != nil {
+			assignError(errOut, err, syscall.EINVAL)
+			return false
+		}
+	}
+
+	if !config.Data().HasSection(oldName) {
+		assignError(errOut, syscall.ENOENT, syscall.ENOENT)
+		return false
+	} else if config.Data().HasSection(newName) {
+		assignError(errOut, syscall.EEXIST, syscall.EEXIST)
+		return false
+	}
+
+	section := getSection(oldName)
+
+	// Remove the old section first so that nothing can create a new VFS
+	// instance for it while the existing ones are being shut down.
+	config.Data().DeleteSection(oldName)
+	shutdownSection(oldName, false)
+	shutdownSection(newName, false)
+
+	// The new remote does not exist, so any cache directories it has are stale.
+	for _, dir := range getVfsCacheDirs(newName) {
+		if err := os.RemoveAll(dir); err != nil {
+			fs.Logf(nil, "Failed to delete stale VFS cache directory: %q: %v", dir, err)
+		}
+	}
+
+	if err := moveVfsCacheDirs(oldName, newName); err != nil {
+		setSection(oldName, section)
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	setSection(newName, section)
+
+	if err := config.Data().Save(); err != nil {
+		config.Data().DeleteSection(newName)
+		setSection(oldName, section)
+
+		if err := moveVfsCacheDirs(newName, oldName); err != nil {
+			fs.Logf(nil, "Failed to move back VFS cache directories: %q: %v", newName, err)
+		}
+
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	fs.Logf(nil, "Renamed remote: %q -> %q", oldName, newName)
+
+	return true
+}
+
+// Delete a remote's config section, including its custom RSAF options. The
+// remote's VFS instances are shut down and the config is saved. The VFS cache
+// directories are deleted only after the config is saved successfully. Fails
+// with ENOENT if the remote does not exist.
+func RbConfigDeleteSection(name string, errOut *RbError) bool {
+	if err := fspath.CheckConfigName(name); err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return false
+	}
+
+	if !config.Data().HasSection(name) {
+		assignError(errOut, syscall.ENOENT, syscall.ENOENT)
+		return false
+	}
+
+	section := getSection(name)
+
+	config.Data().DeleteSection(name)
+	shutdownSection(name, false)
+
+	if err := config.Data().Save(); err != nil {
+		setSection(name, section)
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	for _, dir := range getVfsCacheDirs(name) {
+		if err := os.RemoveAll(dir); err != nil {
+			fs.Logf(nil, "Failed to delete VFS cache directory: %q: %v", dir, err)
+		}
+	}
+
+	fs.Logf(nil, "Deleted remote: %q", name)
+
+	return true
+}
+
+const (
+	// rclone's header for encrypted config files.
+	configEncryptedHeader = "RCLONE_ENCRYPT_V0:"
+)
+
+// Derive the config encryption key from a password the same way as
+// config.SetConfigPassword(), but without changing rclone's global key, which
+// rclone uses whenever it saves the config, like after refreshing OAuth tokens.
+// ImportExportTest checks that rclone can decrypt the output.
+func deriveConfigKey(password string) (*[32]byte, error) {
+	if !utf8.ValidString(password) {
+		return nil, errors.New("password contains invalid utf8 characters")
+	} else if strings.TrimSpace(password) == "" {
+		return nil, errors.New("no characters in password")
+	}
+
+	key := sha256.Sum256([]byte("[" + norm.NFKC.String(password) + "][rclone-config]"))
+
+	return &key, nil
+}
+
+// Split config file data into the encrypted payload and whether the data is
+// encrypted. This matches rclone's logic of checking the first line that is not
+// empty and not a comment.
+func splitConfig(data []byte) ([]byte, bool, error) {
+	rest := data
+
+	for len(rest) > 0 {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+
+		l := strings.TrimSpace(string(line))
+		if len(l) == 0 || strings.HasPrefix(l, ";") || strings.HasPrefix(l, "#") {
+			continue
+		} else if l == configEncryptedHeader {
+			return rest, true, nil
+		} else if strings.HasPrefix(l, "RCLONE_ENCRYPT_V") {
+			return nil, false, errors.New("unsupported config encryption")
+		}
+
+		break
+	}
+
+	return data, false, nil
+}
+
+// Decrypt config file data with the specified password, which must match the
+// password that the data was encrypted with, or be empty if the data is not
+// encrypted. rclone's global config password is not used or changed.
+func decryptConfig(data []byte, password string) ([]byte, error) {
+	payload, encrypted, err := splitConfig(data)
+	if err != nil {
+		return nil, err
+	} else if (password != "") != encrypted {
+		return nil, errors.New("password does not match config file")
+	} else if !encrypted {
+		return data, nil
+	}
+
+	key, err := deriveConfigKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	payload = bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, payload)
+
+	box := make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+	n, err := base64.StdEncoding.Decode(box, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted config: %w", err)
+	}
+	box = box[:n]
+
+	if len(box) < 24+secretbox.Overhead {
+		return nil, errors.New("encrypted config is too short")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], box)
+
+	plainText, ok := secretbox.Open(nil, box[24:], &nonce, key)
+	if !ok {
+		return nil, errors.New("password does not match config file")
+	}
+
+	return plainText, nil
+}
+
+// Encrypt config file data with the specified password in the same format as
+// config.Encrypt(). An empty password means no encryption. rclone's global
+// config password is not used or changed.
+func encryptConfig(data []byte, password string) ([]byte, error) {
+	if password == "" {
+		return data, nil
+	}
+
+	key, err := deriveConfigKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintln(&buf, "# Encrypted rclone configuration File")
+	fmt.Fprintln(&buf, "")
+	fmt.Fprintln(&buf, configEncryptedHeader)
+	buf.WriteString(base64.StdEncoding.EncodeToString(secretbox.Seal(nonce[:], data, &nonce, key)))
+
+	return buf.Bytes(), nil
+}
+
+// Write a file by writing to a temp file next to it, syncing the temp file, and
+// then renaming it over the file. The file's permissions are preserved.
+func writeFileAtomic(path string, write func(io.Writer) error) (err error) {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir, name := filepath.Split(path)
+
+	f, err := os.CreateTemp(dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := f.Chmod(mode); err != nil {
+		return err
+	} else if err := write(f); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// The rename itself is only durable once the directory is synced.
+	if d, err := os.Open(dir); err == nil {
+		if err := d.Sync(); err != nil {
+			fs.Logf(nil, "Failed to sync directory: %q: %v", dir, err)
+		}
+		d.Close()
+	}
+
+	return nil
+}
+
+// Change the password of the config file. The old password must match the one
+// that the file is currently encrypted with, or be empty if the file is not
+// encrypted. Fails with EACCES otherwise. An empty new password removes the
+// encryption. The file is re-encrypted to a temp file, which is synced and then
+// renamed over the original file. If any step fails, the original file and the
+// current password are left untouched. Otherwise, the new password is set,
+// exactly like with RbConfigSetPassword(). The in-memory config is not touched.
+// This must not be called concurrently with RbConfigSave().
+func RbConfigChangePassword(oldPassword string, newPassword string, errOut *RbError) bool {
+	path := config.GetConfigPath()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	plainText, err := decryptConfig(data, oldPassword)
+	if err != nil {
+		assignError(errOut, err, syscall.EACCES)
+		return false
+	}
+
+	cipherText, err := encryptConfig(plainText, newPassword)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return false
+	}
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(cipherText)
+		return err
+	})
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	if newPassword == "" {
+		config.ClearConfigPassword()
+	} else if err := config.SetConfigPassword(newPassword); err != nil {
+		// This can't happen because encryptConfig() already validated the
+		// password.
+		fs.Logf(nil, "Failed to set new config password: %v", err)
+	}
+
+	fs.Logf(nil, "Changed config password")
+
+	return true
+}
+
+type RbConfigExportOptions struct {
+	// The sections to export. nil exports all sections.
+	Sections *RbStringList
+	// Whether to keep options that the backend marks as passwords or as
+	// sensitive, like OAuth tokens and client secrets.
+	KeepSecrets bool
+	// Password for encrypting the exported config. This is unrelated to the
+	// password of the config file itself. An empty string means the exported
+	// config is not encrypted.
+	Password string
+}
+
+type RbConfigRedactedOption struct {
+	Section string
+	Option  string
+}
+
+type RbConfigRedactedOptionList struct {
+	items []RbConfigRedactedOption
+}
+
+func (list *RbConfigRedactedOptionList) Get(index int) *RbConfigRedactedOption {
+	return &list.items[index]
+}
+
+func (list *RbConfigRedactedOptionList) Size() int {
+	return len(list.items)
+}
+
+type RbConfigExportResult struct {
+	// The exported config in rclone's config file format.
+	Data string
+	// The options that were removed.
+	Redacted *RbConfigRedactedOptionList
+}
+
+// Get the names of the options in a config section that the backend marks as
+// passwords or as sensitive.
+func getSecretKeys(name string) (map[string]bool, error) {
+	backend, found := config.Data().GetValue(name, "type")
+	if !found {
+		return nil, fmt.Errorf("%q: remote has no type", name)
+	}
+
+	ri, err := fs.Find(backend)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", name, err)
+	}
+
+	keys := map[string]bool{}
+	for _, option := range ri.Options {
+		if option.IsPassword || option.Sensitive {
+			keys[option.Name] = true
+		}
+	}
+
+	return keys, nil
+}
+
+// Export a subset of the in-memory config for sharing. Unless secrets are kept,
+// every option that the backend marks as a password or as sensitive is removed.
+// Fails with ENOENT if a section does not exist and EINVAL if the sensitive
+// options of a section can't be determined because its backend is unknown.
+// Encrypting the exported config does not affect the config file's password.
+func RbConfigExport(options *RbConfigExportOptions, errOut *RbError) *RbConfigExportResult {
+	if options == nil {
+		options = &RbConfigExportOptions{}
+	}
+
+	sections := config.Data().GetSectionList()
+	if options.Sections != nil {
+		sections = options.Sections.items
+	}
+
+	gc, err := goconfig.LoadFromReader(bytes.NewReader(nil))
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	redacted := []RbConfigRedactedOption{}
+
+	for _, name := range sections {
+		if !config.Data().HasSection(name) {
+			assignError(errOut, fmt.Errorf("%q: %w", name, syscall.ENOENT), syscall.ENOENT)
+			return nil
+		}
+
+		secretKeys := map[string]bool{}
+		if !options.KeepSecrets {
+			secretKeys, err = getSecretKeys(name)
+			if err != nil {
+				assignError(errOut, err, syscall.EINVAL)
+				return nil
+			}
+		}
+
+		for _, kv := range getSection(name) {
+			if secretKeys[kv.Key] {
+				redacted = append(redacted, RbConfigRedactedOption{Section: name, Option: kv.Key})
+				continue
+			}
+
+			gc.SetValue(name, kv.Key, kv.Value)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := goconfig.SaveConfigData(gc, &buf); err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	data := buf.Bytes()
+
+	if options.Password != "" {
+		data, err = encryptConfig(data, options.Password)
+		if err != nil {
+			assignError(errOut, err, syscall.EINVAL)
+			return nil
+		}
+	}
+
+	return &RbConfigExportResult{
+		Data:     string(data),
+		Redacted: &RbConfigRedactedOptionList{items: redacted},
+	}
+}
@@ -0,0 +1,457 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	goSync "sync"
+	"syscall"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+type RbProviderOptionExample struct {
+	Value string
+	Help  string
+	// The provider this example applies to or an empty string if it applies
+	// to all of them.
+	Provider string
+}
+
+type RbProviderOptionExampleList struct {
+	items []RbProviderOptionExample
+}
+
+func (list *RbProviderOptionExampleList) Get(index int) *RbProviderOptionExample {
+	return &list.items[index]
+}
+
+func (list *RbProviderOptionExampleList) Size() int {
+	return len(list.items)
+}
+
+type RbProviderOption struct {
+	// Name of the option in snake_case. This is the config file key.
+	Name string
+	Help string
+	// The provider this option applies to or an empty string if it applies
+	// to all of them.
+	Provider string
+	// The rclone type name, like "string", "bool", or "SizeSuffix".
+	Type string
+	// The default value in rclone's string format.
+	Default string
+	// The current value in rclone's string format. For questions, this is the
+	// value that should be preselected.
+	Value    string
+	Examples *RbProviderOptionExampleList
+	// Whether rclone hides the option from the configurator.
+	Hidden   bool
+	Required bool
+	// Whether the value is stored obscured.
+	IsPassword bool
+	Advanced   bool
+	// Whether the value must be one of the examples.
+	Exclusive bool
+	// Whether the value should be redacted when showing the config.
+	Sensitive bool
+}
+
+type RbProviderOptionList struct {
+	items []RbProviderOption
+}
+
+func (list *RbProviderOptionList) Get(index int) *RbProviderOption {
+	return &list.items[index]
+}
+
+func (list *RbProviderOptionList) Size() int {
+	return len(list.items)
+}
+
+type RbProvider struct {
+	// The remote type, like "drive".
+	Name        string
+	Description string
+	// Prefix for the backend's command line flags.
+	Prefix string
+	// Whether rclone hides the provider from the configurator.
+	Hidden  bool
+	Aliases *RbStringList
+	Options *RbProviderOptionList
+}
+
+type RbProviderList struct {
+	items []RbProvider
+}
+
+func (list *RbProviderList) Get(index int) *RbProvider {
+	return &list.items[index]
+}
+
+func (list *RbProviderList) Size() int {
+	return len(list.items)
+}
+
+func newProviderOption(option *fs.Option) RbProviderOption {
+	examples := []RbProviderOptionExample{}
+	for _, example := range option.Examples {
+		examples = append(examples, RbProviderOptionExample{
+			Value:    example.Value,
+			Help:     example.Help,
+			Provider: example.Provider,
+		})
+	}
+
+	defaultValue := ""
+	if option.Default != nil {
+		defaultValue = fmt.Sprint(option.Default)
+	}
+
+	return RbProviderOption{
+		Name:       option.Name,
+		Help:       option.Help,
+		Provider:   option.Provider,
+		Type:       option.Type(),
+		Default:    defaultValue,
+		Value:      option.String(),
+		Examples:   &RbProviderOptionExampleList{items: examples},
+		Hidden:     option.Hide != 0,
+		Required:   option.Required,
+		IsPassword: option.IsPassword,
+		Advanced:   option.Advanced,
+		Exclusive:  option.Exclusive,
+		Sensitive:  option.Sensitive,
+	}
+}
+
+// List all backend providers supported by rclone, sorted by name, along with
+// their options.
+func RbConfigProviders() *RbProviderList {
+	providers := []RbProvider{}
+
+	for _, ri := range fs.Registry {
+		options := []RbProviderOption{}
+		for _, option := range ri.Options {
+			options = append(options, newProviderOption(&option))
+		}
+
+		providers = append(providers, RbProvider{
+			Name:        ri.Name,
+			Description: ri.Description,
+			Prefix:      ri.Prefix,
+			Hidden:      ri.Hide,
+			Aliases:     &RbStringList{items: slices.Clone(ri.Aliases)},
+			Options:     &RbProviderOptionList{items: options},
+		})
+	}
+
+	slices.SortFunc(providers, func(a, b RbProvider) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return &RbProviderList{items: providers}
+}
+
+var (
+	// Questions that are answered automatically. If config_is_local is true,
+	// rclone runs the OAuth flow itself and blocks until it completes. The
+	// host must use RbAuthorizeStart() instead.
+	configAutoAnswers = map[string]string{
+		"config_is_local": "false",
+	}
+)
+
+// Create the question for selecting the remote type. rclone doesn't have an
+// equivalent because the type must be known before the state machine can run.
+func newTypeQuestion() *fs.Option {
+	providers := slices.Clone(fs.Registry)
+	slices.SortFunc(providers, func(a, b *fs.RegInfo) int {
+		return strings.Compare(a.Description, b.Description)
+	})
+
+	examples := fs.OptionExamples{}
+	for _, ri := range providers {
+		examples = append(examples, fs.OptionExample{
+			Value: ri.Name,
+			Help:  ri.Description,
+		})
+	}
+
+	return &fs.Option{
+		Name: "type",
+		// Same string as in `rclone config`.
+		Help:      "Type of storage to configure.",
+		Default:   "",
+		Examples:  examples,
+		Required:  true,
+		Exclusive: true,
+	}
+}
+
+type configStep struct {
+	// The answer that was submitted to reach this step.
+	prevAnswer string
+	state      string
+	err        string
+	option     *fs.Option
+	isType     bool
+}
+
+type RbConfigQuestion struct {
+	// An error about the previous answer that should be shown to the user or
+	// an empty string if there is none.
+	Error  string
+	Option *RbProviderOption
+}
+
+// A session for running rclone's config state machine for a remote one
+// question at a time. The session keeps the history of answers so that it's
+// possible to go back to previous questions. All methods are safe to call from
+// multiple threads.
+type RbConfigSession struct {
+	lock    goSync.Mutex
+	remote  string
+	steps   []configStep
+	skipped int
+}
+
+// Start configuring a remote. The remote name does not include the trailing
+// colon. If the remote does not exist yet, the first question asks for the
+// remote type and the remote is created once it is answered.
+func RbConfigSessionStart(remote string, errOut *RbError) *RbConfigSession {
+	if err := fspath.CheckConfigName(remote); err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	session := &RbConfigSession{remote: remote}
+
+	if slices.Contains(config.GetRemoteNames(), remote) {
+		err := session.submit("")
+		if err != nil {
+			assignError(errOut, err, syscall.EIO)
+			return nil
+		}
+	} else {
+		session.steps = append(session.steps, configStep{
+			option: newTypeQuestion(),
+			isType: true,
+		})
+	}
+
+	return session
+}
+
+// Get the question that should be answered next or nil if configuration is
+// complete.
+func (session *RbConfigSession) Question() *RbConfigQuestion {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if len(session.steps) == 0 {
+		return nil
+	}
+
+	step := session.steps[len(session.steps)-1]
+	if step.option == nil {
+		return nil
+	}
+
+	option := newProviderOption(step.option)
+
+	return &RbConfigQuestion{
+		Error:  step.err,
+		Option: &option,
+	}
+}
+
+// Whether it's possible to go back to a previous question. It is not possible
+// to go back to the remote type question because the remote has already been
+// created at that point.
+func (session *RbConfigSession) HasPrevious() bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	return session.hasPrevious()
+}
+
+func (session *RbConfigSession) hasPrevious() bool {
+	return len(session.steps)+session.skipped >= 2
+}
+
+// Submit an answer to the current question. Plain text passwords are obscured
+// automatically. Questions that rcbridge answers automatically are skipped.
+func (session *RbConfigSession) Submit(answer string, errOut *RbError) bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if err := session.submit(answer); err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	for {
+		answer, ok := session.autoAnswer()
+		if !ok {
+			break
+		}
+
+		if err := session.submit(answer); err != nil {
+			assignError(errOut, err, syscall.EIO)
+			return false
+		}
+		session.skipped += 1
+	}
+
+	return true
+}
+
+// Go back to the previous question, skipping over automatically answered
+// questions. Fails with EINVAL if there is no previous question.
+func (session *RbConfigSession) GoBack(errOut *RbError) bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if !session.hasPrevious() {
+		assignError(errOut, syscall.EINVAL, syscall.EINVAL)
+		return false
+	}
+
+	if err := session.submit(session.popAnswer()); err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	for {
+		if _, ok := session.autoAnswer(); !ok {
+			break
+		}
+
+		session.skipped -= 1
+
+		if err := session.submit(session.popAnswer()); err != nil {
+			assignError(errOut, err, syscall.EIO)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Get the automatic answer for the current question, if any.
+//
+// Must be called with the lock held.
+func (session *RbConfigSession) autoAnswer() (string, bool) {
+	if len(session.steps) == 0 {
+		return "", false
+	}
+
+	option := session.steps[len(session.steps)-1].option
+	if option == nil {
+		return "", false
+	}
+
+	answer, ok := configAutoAnswers[option.Name]
+	return answer, ok
+}
+
+// Remove the current step and the previous step, returning the answer that
+// was submitted to the step before that. Resubmitting the answer recreates
+// the previous step.
+//
+// Must be called with the lock held.
+func (session *RbConfigSession) popAnswer() string {
+	session.steps = session.steps[:len(session.steps)-1]
+	answer := session.steps[len(session.steps)-1].prevAnswer
+	session.steps = session.steps[:len(session.steps)-1]
+
+	return answer
+}
+
+// Run one step of the state machine with the answer to the current question.
+//
+// Must be called with the lock held.
+func (session *RbConfigSession) submit(answer string) error {
+	var step *configStep
+	if len(session.steps) > 0 {
+		step = &session.steps[len(session.steps)-1]
+	}
+
+	opt := config.UpdateRemoteOpt{
+		Obscure:        true,
+		NonInteractive: true,
+		All:            true,
+	}
+	if step != nil {
+		opt.State = step.state
+	}
+
+	ctx := context.Background()
+	prevState := ""
+
+	var out *fs.ConfigOut
+	var err error
+
+	if step != nil && step.isType {
+		out, err = config.CreateRemote(ctx, session.remote, answer, rc.Params{}, opt)
+		if err != nil {
+			return err
+		}
+
+		// The remote exists now, so this is the same as if an existing remote
+		// was being edited.
+		session.steps = session.steps[:len(session.steps)-1]
+		answer = ""
+	} else {
+		result := answer
+
+		// The Obscure option does not apply to the result.
+		if step != nil && step.option != nil && step.option.IsPassword && result != "" {
+			result, err = obscure.Obscure(result)
+			if err != nil {
+				return err
+			}
+		}
+
+		opt.Continue = true
+		opt.Result = result
+
+		out, err = config.UpdateRemote(ctx, session.remote, rc.Params{}, opt)
+		if err != nil {
+			return err
+		}
+
+		if step != nil {
+			prevState = step.state
+		}
+	}
+
+	if out == nil {
+		out = &fs.ConfigOut{}
+	}
+
+	newStep := configStep{
+		prevAnswer: answer,
+		state:      out.State,
+		err:        out.Error,
+		option:     out.Option,
+	}
+
+	// Avoid duplicates when the same question is asked again after an error.
+	if len(session.steps) > 0 && newStep.state == prevState {
+		session.steps[len(session.steps)-1] = newStep
+	} else {
+		session.steps = append(session.steps, newStep)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rclone/rclone/fs/fserrors"
+)
+
+const (
+	RbErrorCategoryUnknown = iota
+	RbErrorCategoryAuth
+	RbErrorCategoryQuota
+	RbErrorCategoryNetwork
+	RbErrorCategoryRateLimited
+	RbErrorCategoryNotFound
+	RbErrorCategoryPermission
+	RbErrorCategoryConflict
+)
+
+var (
+	// lib/rest's default error handler only reports the status in the message.
+	httpErrorRegex = regexp.MustCompile(`HTTP error (\d{3})`)
+
+	// Backends generally don't return typed errors for these conditions, so
+	// there's no way around matching the (lowercased) error messages.
+	authErrorStrings = []string{
+		"invalid_grant",
+		"invalid_client",
+		"unauthorized_client",
+		"token expired",
+		"rclone config reconnect",
+		"empty token found",
+	}
+	quotaErrorStrings = []string{
+		"quota",
+		"insufficient storage",
+		"insufficient_space",
+		"storage full",
+		"not enough space",
+	}
+	rateLimitErrorStrings = []string{
+		"rate limit",
+		"ratelimit",
+		"too many requests",
+	}
+)
+
+// The status code accessor implemented by the AWS SDK's errors.
+type httpStatusCoder interface {
+	HTTPStatusCode() int
+}
+
+// Try to find the HTTP status code in a single error's fields. Most backends'
+// API error types include the status code under one of these names and
+// oauth2.RetrieveError includes the full response.
+func httpStatusFromFields(err error) int {
+	v := reflect.ValueOf(err)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+
+	if field := v.FieldByName("Response"); field.IsValid() && field.CanInterface() {
+		if resp, ok := field.Interface().(*http.Response); ok && resp != nil {
+			return resp.StatusCode
+		}
+	}
+
+	for _, name := range []string{"StatusCode", "HTTPStatusCode", "Code"} {
+		field := v.FieldByName(name)
+		if field.IsValid() && field.CanInt() {
+			if status := int(field.Int()); status >= 100 && status <= 599 {
+				return status
+			}
+		}
+	}
+
+	return 0
+}
+
+// Find the HTTP status code associated with an error or return 0 if there is
+// none.
+func httpStatusFromError(err error) int {
+	var coder httpStatusCoder
+	if errors.As(err, &coder) {
+		return coder.HTTPStatusCode()
+	}
+
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if status := httpStatusFromFields(e); status != 0 {
+			return status
+		}
+	}
+
+	if match := httpErrorRegex.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		return status
+	}
+
+	return 0
+}
+
+func containsAny(haystack string, needles []string) bool {
+	for _, needle := range needles {
+		if strings.Contains(haystack, needle) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isNetworkError(err error, code syscall.Errno) bool {
+	switch code {
+	case syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED,
+		syscall.ENETUNREACH, syscall.ENETDOWN, syscall.EHOSTUNREACH,
+		syscall.ETIMEDOUT:
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Classify an error so that the UI can offer appropriate actions, like
+// reauthenticating or retrying. errOut.Code must already be set.
+func assignErrorCategory(errOut *RbError, err error) {
+	code := syscall.Errno(errOut.Code)
+	status := httpStatusFromError(err)
+	msg := strings.ToLower(err.Error())
+
+	errOut.HttpStatus = status
+	errOut.RetryAfter = 0
+
+	if retryAfter := fserrors.RetryAfterErrorTime(err); !retryAfter.IsZero() {
+		errOut.RetryAfter = retryAfter.UnixMilli()
+	}
+
+	switch {
+	case fserrors.IsRetryAfterError(err) || status == http.StatusTooManyRequests ||
+		containsAny(msg, rateLimitErrorStrings):
+		errOut.Category = RbErrorCategoryRateLimited
+	case fserrors.IsErrNoSpace(err) || code == syscall.ENOSPC || code == syscall.EDQUOT ||
+		status == http.StatusInsufficientStorage || containsAny(msg, quotaErrorStrings):
+		errOut.Category = RbErrorCategoryQuota
+	case status == http.StatusUnauthorized || containsAny(msg, authErrorStrings):
+		errOut.Category = RbErrorCategoryAuth
+	case status == http.StatusForbidden || code == syscall.EACCES || code == syscall.EPERM:
+		errOut.Category = RbErrorCategoryPermission
+	case status == http.StatusNotFound || status == http.StatusGone || code == syscall.ENOENT:
+		errOut.Category = RbErrorCategoryNotFound
+	case status == http.StatusConflict || status == http.StatusPreconditionFailed ||
+		code == syscall.EEXIST || code == syscall.ENOTEMPTY:
+		errOut.Category = RbErrorCategoryConflict
+	case isNetworkError(err, code) || (status == 0 && fserrors.ShouldRetry(err)):
+		errOut.Category = RbErrorCategoryNetwork
+	default:
+		errOut.Category = RbErrorCategoryUnknown
+	}
+
+	if fserrors.IsNoRetryError(err) || fserrors.IsFatalError(err) || code == syscall.ECANCELED {
+		errOut.Retryable = false
+	} else {
+		errOut.Retryable = fserrors.IsRetryError(err) ||
+			fserrors.ShouldRetry(err) ||
+			errOut.Category == RbErrorCategoryRateLimited ||
+			errOut.Category == RbErrorCategoryNetwork ||
+			status == http.StatusRequestTimeout ||
+			(status >= 500 && errOut.Category != RbErrorCategoryQuota)
+	}
+}
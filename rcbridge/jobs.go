@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	"fmt"
+	goSync "sync"
+	"syscall"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+type rbJob struct {
+	group  string
+	cancel context.CancelFunc
+	done   chan struct{}
+	// Only valid after done is closed.
+	err      error
+	fallback syscall.Errno
+}
+
+var (
+	jobsLock  goSync.Mutex
+	jobs      = make(map[int64]*rbJob)
+	nextJobId = int64(1)
+)
+
+func getJob(id int64) (*rbJob, error) {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+
+	job, ok := jobs[id]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	return job, nil
+}
+
+// Start copying or moving a document in the background. The semantics are the
+// same as RbDocCopyOrMove(). Returns the job ID, which can be used to query the
+// progress. RbJobRemove() must be called once the caller is done with the job.
+func RbDocCopyOrMoveStart(sourceDoc string, targetDoc string, copy bool) int64 {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+
+	id := nextJobId
+	nextJobId += 1
+
+	// All transfers performed by the job are accounted for in a separate stats
+	// group so that the progress of concurrent jobs can be tracked separately.
+	group := fmt.Sprintf("rsaf/job/%d", id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = accounting.WithStatsGroup(ctx, group)
+
+	job := &rbJob{
+		group:  group,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	jobs[id] = job
+
+	go func() {
+		job.fallback, job.err = copyOrMove(ctx, sourceDoc, targetDoc, copy)
+		close(job.done)
+	}()
+
+	return id
+}
+
+type RbJobStatus struct {
+	// Whether the job has completed, successfully or not. Use RbJobWait() to
+	// get the result.
+	Finished bool
+	// Number of bytes transferred so far.
+	Bytes int64
+	// Total number of bytes to transfer, including those already transferred.
+	// This increases as rclone discovers more files to transfer.
+	TotalBytes int64
+	// Number of files transferred so far.
+	Transfers int64
+	// Total number of files to transfer, including those already transferred.
+	TotalTransfers int64
+	// Number of errors encountered so far. Individual file errors may be
+	// retried, so this does not necessarily mean the job will fail.
+	Errors int64
+	// Name of a file currently being transferred or an empty string if there
+	// are none.
+	CurrentFile string
+	// Average transfer speed in bytes per second.
+	Speed float64
+	// Estimated number of seconds until the job is complete or -1 if unknown.
+	Eta int64
+}
+
+// Get the current progress of a job.
+func RbJobGetStatus(id int64, errOut *RbError) *RbJobStatus {
+	job, err := getJob(id)
+	if err != nil {
+		assignError(errOut, err, syscall.ENOENT)
+		return nil
+	}
+
+	stats, err := accounting.StatsGroup(context.Background(), job.group).RemoteStats(false)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	status := RbJobStatus{
+		Eta: -1,
+	}
+
+	select {
+	case <-job.done:
+		status.Finished = true
+	default:
+	}
+
+	// None of these can fail unless rclone changes the types.
+	status.Bytes, _ = stats.GetInt64("bytes")
+	status.TotalBytes, _ = stats.GetInt64("totalBytes")
+
+	// Server-side copies and moves are counted separately and only once they
+	// are complete, so they're in neither of the above.
+	serverSideCopyBytes, _ := stats.GetInt64("serverSideCopyBytes")
+	serverSideMoveBytes, _ := stats.GetInt64("serverSideMoveBytes")
+	status.Bytes += serverSideCopyBytes + serverSideMoveBytes
+	status.TotalBytes += serverSideCopyBytes + serverSideMoveBytes
+	status.Transfers, _ = stats.GetInt64("transfers")
+	status.TotalTransfers, _ = stats.GetInt64("totalTransfers")
+	status.Errors, _ = stats.GetInt64("errors")
+	status.Speed, _ = stats.GetFloat64("speed")
+
+	if eta, err := stats.GetFloat64("eta"); err == nil {
+		status.Eta = int64(eta)
+	}
+
+	if transferring, ok := stats["transferring"].([]rc.Params); ok && len(transferring) > 0 {
+		status.CurrentFile, _ = transferring[0].GetString("name")
+	}
+
+	return &status
+}
+
+// Wait for a job to finish and return its result. If the token is cancelled,
+// this stops waiting, but the job itself keeps running.
+func RbJobWait(id int64, token *RbCancelToken, errOut *RbError) bool {
+	job, err := getJob(id)
+	if err != nil {
+		assignError(errOut, err, syscall.ENOENT)
+		return false
+	}
+
+	ctx := tokenContext(token)
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		assignError(errOut, ctx.Err(), syscall.ECANCELED)
+		return false
+	}
+
+	if job.err != nil {
+		assignError(errOut, job.err, job.fallback)
+		return false
+	}
+
+	return true
+}
+
+// Cancel a job. This returns immediately. Use RbJobWait() to wait for the job
+// to actually stop.
+func RbJobCancel(id int64, errOut *RbError) bool {
+	job, err := getJob(id)
+	if err != nil {
+		assignError(errOut, err, syscall.ENOENT)
+		return false
+	}
+
+	job.cancel()
+
+	return true
+}
+
+// Cancel the job if it's still running and release all resources associated
+// with it. The job ID is no longer valid after this is called.
+func RbJobRemove(id int64) {
+	jobsLock.Lock()
+	job, ok := jobs[id]
+	delete(jobs, id)
+	jobsLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	job.cancel()
+
+	go func() {
+		<-job.done
+
+		// There's no public API for deleting a stats group aside from RC.
+		call := rc.Calls.Get("core/stats-delete")
+		_, err := call.Fn(context.Background(), rc.Params{"group": job.group})
+		if err != nil {
+			fs.Logf(nil, "Failed to delete stats group: %v: %v", job.group, err)
+		}
+	}()
+}
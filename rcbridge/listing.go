@@ -0,0 +1,290 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/vfs"
+)
+
+// A cursor for incrementally listing the contents of a directory or the results
+// of a search. Unlike RbDocListDir(), the listing is streamed from the backend
+// as it is produced, so the entries are not sorted and the VFS directory cache
+// is bypassed. When listing a directory, files in the VFS cache that are waiting
+// to be uploaded are taken from the VFS instead and are returned last. Files
+// that are still open for writing are not included until they are closed.
+type RbDirListing struct {
+	doc string
+	f   fs.Fs
+	// Path of doc relative to the root of f with no leading or trailing
+	// slashes.
+	dir       string
+	dirPerms  os.FileMode
+	filePerms os.FileMode
+	ctx       context.Context
+	cancel    context.CancelFunc
+	batches   chan fs.DirEntries
+	pending   fs.DirEntries
+	// Whether batches was closed.
+	done bool
+	// Only valid after batches is closed.
+	err error
+	// Entries from the VFS to return after the backend entries. Only valid
+	// after batches is closed.
+	extra []RbDirEntry
+}
+
+// List a directory page by page if the backend supports it. Otherwise, fall
+// back to listing the entire directory at once. This is the same as rclone's
+// internal list.listP().
+func listP(ctx context.Context, f fs.Fs, dir string, callback fs.ListRCallback) error {
+	if doListP := f.Features().ListP; doListP != nil {
+		return doListP(ctx, dir, callback)
+	}
+
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	return callback(entries)
+}
+
+// Start listing the contents of a directory. The listing runs in the
+// background and entries are retrieved with Next(). Close() must be called
+// when the listing is no longer needed, even if it is complete. Cancelling the
+// token has the same effect as calling Close().
+func RbDocListDirOpen(doc string, token *RbCancelToken, errOut *RbError) *RbDirListing {
+	if err := checkNetworkForDoc(doc); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return nil
+	}
+
+	v, docPath, err := getVfsForDoc(doc)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	node, err := v.Stat(docPath)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	} else if !node.IsDir() {
+		assignError(errOut, syscall.ENOTDIR, syscall.ENOTDIR)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(tokenContext(token))
+
+	listing := newDirListing(ctx, cancel, v, doc, docPath)
+
+	go listing.run(func(callback fs.ListRCallback) error {
+		uploads, err := getPendingUploads(v, listing.dir)
+		if err != nil {
+			fs.Logf(doc, "Failed to get pending uploads: %v", err)
+		}
+
+		err = listP(ctx, listing.f, docPath, func(entries fs.DirEntries) error {
+			// The backend's entries are outdated for these.
+			entries = slices.DeleteFunc(slices.Clone(entries), func(entry fs.DirEntry) bool {
+				_, ok := uploads[path.Base(entry.Remote())]
+				return ok
+			})
+			if len(entries) == 0 {
+				return nil
+			}
+
+			return callback(entries)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(uploads)) {
+			node, err := v.Stat(path.Join(listing.dir, name))
+			if err != nil {
+				// The file was deleted after the upload queue was queried.
+				continue
+			}
+
+			listing.extra = append(listing.extra, newDirEntry(ctx, node, doc, true, false))
+		}
+
+		return nil
+	})
+
+	return listing
+}
+
+// Get the names of the files directly inside the directory that are in the VFS
+// upload queue. The directory path is relative to the root of the VFS.
+func getPendingUploads(v *vfs.VFS, dir string) (map[string]struct{}, error) {
+	queue, err := getUploadQueue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make(map[string]struct{})
+
+	for _, item := range queue {
+		parent, name := path.Split(item.Name)
+		if strings.TrimSuffix(parent, "/") == dir {
+			uploads[name] = struct{}{}
+		}
+	}
+
+	return uploads, nil
+}
+
+func newDirListing(ctx context.Context, cancel context.CancelFunc, v *vfs.VFS, doc string, dir string) *RbDirListing {
+	return &RbDirListing{
+		doc:       doc,
+		f:         v.Fs(),
+		dir:       strings.Trim(path.Clean("/"+dir), "/"),
+		dirPerms:  os.FileMode(v.Opt.DirPerms),
+		filePerms: os.FileMode(v.Opt.FilePerms),
+		ctx:       ctx,
+		cancel:    cancel,
+		batches:   make(chan fs.DirEntries),
+	}
+}
+
+// Run the function that produces the entries and pass each batch to Next().
+// This blocks until the function returns.
+func (listing *RbDirListing) run(list func(callback fs.ListRCallback) error) {
+	err := list(func(entries fs.DirEntries) error {
+		select {
+		case listing.batches <- entries:
+			return nil
+		case <-listing.ctx.Done():
+			return listing.ctx.Err()
+		}
+	})
+
+	listing.err = err
+	close(listing.batches)
+}
+
+// Move the next batch from the backend into the pending list. If block is
+// false, this returns immediately if no batch is ready yet. Returns false if no
+// batch was received.
+func (listing *RbDirListing) receive(block bool) bool {
+	var batch fs.DirEntries
+	var ok bool
+
+	if block {
+		batch, ok = <-listing.batches
+	} else {
+		select {
+		case batch, ok = <-listing.batches:
+		default:
+			return false
+		}
+	}
+
+	if !ok {
+		listing.done = true
+		return false
+	}
+
+	listing.pending = batch
+	return true
+}
+
+// Get the path of an entry relative to the directory being listed.
+func (listing *RbDirListing) relPath(entry fs.DirEntry) string {
+	if listing.dir == "" {
+		return entry.Remote()
+	}
+
+	return strings.TrimPrefix(entry.Remote(), listing.dir+"/")
+}
+
+func (listing *RbDirListing) toDirEntry(entry fs.DirEntry) RbDirEntry {
+	name := path.Base(entry.Remote())
+
+	var size int64
+	var mode os.FileMode
+
+	if _, ok := entry.(fs.Directory); ok {
+		mode = listing.dirPerms
+	} else {
+		size = max(entry.Size(), 0)
+		mode = listing.filePerms
+	}
+
+	result := RbDirEntry{
+		Doc:     fspath.JoinRootPath(listing.doc, listing.relPath(entry)),
+		Name:    name,
+		Size:    size,
+		Mode:    fileModeToStatMode(mode),
+		ModTime: entry.ModTime(listing.ctx).UnixMilli(),
+	}
+
+	result.fillDetails(listing.ctx, listing.f, entry, false)
+
+	return result
+}
+
+// Get up to maxEntries entries from the listing. This blocks until at least one
+// entry is available, but will not wait for more entries to fill up the page
+// if some are already available. An empty list is returned once the end of the
+// listing is reached.
+//
+// If the listing fails partway through, the entries received so far are
+// returned first and the error is reported on the following call.
+func (listing *RbDirListing) Next(maxEntries int, errOut *RbError) *RbDirEntryList {
+	if maxEntries <= 0 {
+		assignError(errOut, syscall.EINVAL, syscall.EINVAL)
+		return nil
+	}
+
+	entries := []RbDirEntry{}
+
+	for len(entries) < maxEntries {
+		if len(listing.pending) == 0 {
+			if listing.receive(len(entries) == 0) {
+				continue
+			} else if !listing.done || listing.err != nil || len(listing.extra) == 0 {
+				break
+			}
+
+			n := min(maxEntries-len(entries), len(listing.extra))
+			entries = append(entries, listing.extra[:n]...)
+			listing.extra = listing.extra[n:]
+			continue
+		}
+
+		n := min(maxEntries-len(entries), len(listing.pending))
+
+		for _, entry := range listing.pending[:n] {
+			entries = append(entries, listing.toDirEntry(entry))
+		}
+
+		listing.pending = listing.pending[n:]
+	}
+
+	if len(entries) == 0 && listing.err != nil {
+		assignError(errOut, listing.err, syscall.EIO)
+		return nil
+	}
+
+	return &RbDirEntryList{items: entries}
+}
+
+// Stop the listing. This may be called from another thread to interrupt a
+// blocked Next() call.
+func (listing *RbDirListing) Close() {
+	listing.cancel()
+}
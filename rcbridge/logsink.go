@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	"log/slog"
+	goSync "sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/log"
+)
+
+type RbLogRecord struct {
+	// The rclone log level, which is the syslog severity (0 = emergency, 7 =
+	// debug).
+	Level int
+	// Name of the log level, like "ERROR".
+	LevelName string
+	// The remote, object, or other item the message is about. This is empty if
+	// the message is not associated with anything.
+	Object string
+	// The golang type of Object.
+	ObjectType string
+	Message    string
+	// Unix timestamp in milliseconds.
+	Timestamp int64
+}
+
+// Interface to be implemented by the host to receive log records. Log() is
+// called synchronously from whichever thread logged the message, so it should
+// return quickly and must not call back into rcbridge.
+type RbLogSink interface {
+	Log(record *RbLogRecord)
+}
+
+var (
+	logSinkLock goSync.RWMutex
+	logSink     RbLogSink
+)
+
+// slog handler that passes every record to the registered log sink in addition
+// to rclone's normal log output. It also keeps track of upload errors, which
+// rclone only reports via logging.
+type sinkHandler struct {
+	slog.Handler
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	logSinkLock.RLock()
+	sink := logSink
+	logSinkLock.RUnlock()
+
+	level := slogToLogLevel(r.Level)
+
+	record := RbLogRecord{
+		Level:     int(level),
+		LevelName: level.String(),
+		Message:   r.Message,
+		Timestamp: r.Time.UnixMilli(),
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "object":
+			record.Object = a.Value.String()
+		case "objectType":
+			record.ObjectType = a.Value.String()
+		}
+		return true
+	})
+
+	if level <= fs.LogLevelError {
+		recordUploadError(record.Object, record.Message)
+	}
+
+	if sink != nil {
+		sink.Log(&record)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sinkHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	return &sinkHandler{h.Handler.WithGroup(name)}
+}
+
+// Map a slog level back to the nearest rclone log level.
+func slogToLogLevel(level slog.Level) fs.LogLevel {
+	for l := fs.LogLevelEmergency; l < fs.LogLevelOff; l++ {
+		if level >= fs.LogLevelToSlog(l) {
+			return l
+		}
+	}
+
+	return fs.LogLevelDebug
+}
+
+// Install the slog handler that forwards records to the log sink. This is only
+// done once during initialization because rclone's logger cannot be swapped out
+// safely while other goroutines are logging.
+func installLogSinkHandler() {
+	fs.SetLogger(&sinkHandler{log.Handler})
+}
+
+// Register a log sink to receive all log records that pass the current log
+// verbosity. Messages are still written to stderr as well. Only one sink can be
+// registered at a time. Pass nil to unregister the existing sink.
+func RbSetLogSink(sink RbLogSink) {
+	logSinkLock.Lock()
+	defer logSinkLock.Unlock()
+
+	logSink = sink
+}
@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"strconv"
+	"strings"
+	goSync "sync"
+	"syscall"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscache/writeback"
+)
+
+const (
+	// Per-remote option to only use the network when it is unmetered.
+	rsafWifiOnly = "rsaf:wifi_only"
+)
+
+var (
+	// Protects the network state and the pause and cache purge state of every
+	// VFS instance.
+	networkLock      goSync.Mutex
+	networkConnected = true
+	networkMetered   = false
+)
+
+// Check whether the remote is configured to only use unmetered networks.
+func isWifiOnly(remote string) bool {
+	parsed, err := fspath.Parse(remote)
+	if err != nil {
+		return false
+	}
+
+	value, found := config.Data().GetValue(parsed.Name, rsafWifiOnly)
+	if !found {
+		return false
+	}
+
+	wifiOnly, err := strconv.ParseBool(value)
+	if err != nil {
+		fs.Logf(remote, "Invalid %s value: %q", rsafWifiOnly, value)
+		return false
+	}
+
+	return wifiOnly
+}
+
+// Check whether the remote is allowed to use the network in its current state.
+//
+// Must be called with networkLock held.
+func isNetworkAllowed(remote string) bool {
+	if !networkConnected {
+		return false
+	} else if networkMetered && isWifiOnly(remote) {
+		return false
+	}
+
+	return true
+}
+
+// Fail with ENETUNREACH if the remote is not allowed to use the network. This
+// is for operations that always need the network. Operations that go through
+// the VFS are still allowed because they may be satisfied by the caches.
+func checkNetwork(remote string) error {
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	if !isNetworkAllowed(remote) {
+		return syscall.ENETUNREACH
+	}
+
+	return nil
+}
+
+// Same as checkNetwork(), but for the remote containing the document.
+func checkNetworkForDoc(doc string) error {
+	remote, _, err := fspath.SplitFs(doc)
+	if err != nil {
+		return err
+	}
+
+	return checkNetwork(remote)
+}
+
+// Postpone or reschedule every queued upload that isn't in progress or
+// cancelled.
+func setPausedUploads(v *vfs.VFS, pause bool) error {
+	queue, err := getUploadQueue(v)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range queue {
+		if item.Uploading || item.Expiry > uploadCancelThreshold.Seconds() {
+			continue
+		}
+
+		paused := item.Expiry > uploadPauseThreshold.Seconds()
+		if paused == pause {
+			continue
+		}
+
+		// When resuming, this also restarts the writeback timer, which
+		// rclone stops when it can't start any more uploads.
+		expiry := time.Duration(0)
+		if pause {
+			expiry = uploadPauseExpiry
+		}
+
+		_, err := callVfsRc(v, "vfs/queue-set-expiry", rc.Params{
+			"id":     int64(item.ID),
+			"expiry": expiry.Seconds(),
+		})
+		if err != nil && !strings.Contains(err.Error(), writeback.ErrorIDNotFound.Error()) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pause any uploads that were queued after the VFS's uploads were paused.
+// rclone queues new items and retries of failed uploads with its normal delay.
+func repauseUploads(v *vfs.VFS) {
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	for remote, state := range getVfsStates() {
+		if state.pausedVfs != v {
+			continue
+		}
+
+		if err := setPausedUploads(v, true); err != nil {
+			fs.Logf(remote, "Failed to pause uploads: %v", err)
+		}
+	}
+}
+
+// Pause or resume the VFS's queued uploads and cache cleanup to match the
+// network state. rclone has no way to pause the writeback queue, so uploads are
+// paused by postponing them, the same way as RbVfsUploadCancel(). Uploads that
+// are already in progress are left alone, but are paused if they fail and are
+// queued again. The cache cleaner is paused so that cached files stay available
+// while the network can't be used to download them again.
+//
+// Must be called with networkLock held.
+func applyNetworkState(remote string, state *vfsState) {
+	v := state.v.Load()
+	if v == nil {
+		return
+	}
+
+	pause := !isNetworkAllowed(remote)
+
+	if pause == (state.pausedVfs == v) {
+		return
+	}
+
+	if pause {
+		fs.Logf(remote, "Pausing uploads and cache cleanup")
+		state.pausedVfs = v
+	} else {
+		fs.Logf(remote, "Resuming uploads and cache cleanup")
+		state.pausedVfs = nil
+	}
+
+	applyCacheMaxAge(state, v)
+
+	if err := setPausedUploads(v, pause); err != nil {
+		fs.Logf(remote, "Failed to pause or resume uploads: %v", err)
+	}
+}
+
+// Same as applyNetworkState(), but for all existing VFS instances.
+//
+// Must be called with networkLock held.
+func applyAllNetworkStates() {
+	for remote, state := range getVfsStates() {
+		applyNetworkState(remote, state)
+	}
+}
+
+// Set the device's network state. While disconnected, and while connected to a
+// metered network for remotes with the rsaf:wifi_only config option set to
+// true, queued VFS uploads and cache cleanup are paused and operations that
+// always need the network fail with ENETUNREACH. The VFS continues to serve
+// whatever is cached. This function must be called again after the
+// rsaf:wifi_only option is changed for existing VFS instances to pick up the
+// new value.
+func RbSetNetworkState(connected bool, metered bool) {
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	if connected != networkConnected || metered != networkMetered {
+		fs.Logf(nil, "Network state changed: connected=%t, metered=%t", connected, metered)
+	}
+
+	networkConnected = connected
+	networkMetered = metered
+
+	applyAllNetworkStates()
+}
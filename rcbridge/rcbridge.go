@@ -22,14 +22,19 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	ioFs "io/fs"
+	"maps"
 	"os"
+	"path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	goSync "sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	_ "unsafe"
@@ -40,9 +45,11 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/configstruct"
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fs/sync"
 	"github.com/rclone/rclone/lib/oauthutil"
@@ -56,12 +63,38 @@ const (
 	rsafVfsPrefix        = "rsaf:vfs:"
 )
 
+// The VFS state for a single remote. Creating or shutting down a remote's VFS
+// only holds that remote's lock, so a slow remote, like one with many dirty
+// items to reload from the VFS cache, does not block operations on the others.
+type vfsState struct {
+	// Serializes creating and shutting down the VFS.
+	lock goSync.Mutex
+	// The VFS instance or nil if it has not been created. This can be read
+	// without holding the lock.
+	v atomic.Pointer[vfs.VFS]
+	// The VFS instance whose uploads are paused because the network can't be
+	// used, if any. This is only accessed while holding networkLock.
+	pausedVfs *vfs.VFS
+	// The cache max age that the VFS was created with. v.Opt.CacheMaxAge is
+	// changed while purging the cache or while cleanup is paused. This is only
+	// accessed while holding networkLock.
+	cacheMaxAge fs.Duration
+	// Whether the state was removed from vfsStates by shutdown(). A new state
+	// must be used to create the VFS again. This is only accessed while
+	// holding lock.
+	removed bool
+}
+
+// Returned by vfsState.create() if the state was removed by shutdown().
+var errVfsStateRemoved = errors.New("VFS state was removed")
+
 var (
+	// Only protects the vfsStates map itself. This is never held while
+	// creating a VFS and is only held briefly while shutting one down.
 	vfsLock          goSync.Mutex
-	vfsInstances     = make(map[string]*vfs.VFS)
+	vfsStates        = make(map[string]*vfsState)
 	vfsOptValidKeys  = make(map[string]bool)
 	vfsOptStringKeys = make(map[string]bool)
-	stdoutHijackLock goSync.Mutex
 )
 
 func init() {
@@ -231,6 +264,7 @@ func generateTrustStorePool() *x509.CertPool {
 // Initialize global aspects of the library.
 func RbInit() {
 	librclone.Initialize()
+	installLogSinkHandler()
 
 	ci := fs.GetConfig(context.Background())
 
@@ -318,69 +352,172 @@ func RbVersion() string {
 type RbError struct {
 	Msg  string
 	Code int
+	// One of the RbErrorCategory* constants.
+	Category int
+	// Whether the operation might succeed if retried later.
+	Retryable bool
+	// The HTTP status code returned by the server or 0 if unknown.
+	HttpStatus int
+	// Unix timestamp in milliseconds after which the operation can be retried
+	// or 0 if the server didn't say.
+	RetryAfter int64
+}
+
+// A token for cancelling in-progress operations from another thread. A nil
+// token is valid and means that the operation cannot be cancelled.
+type RbCancelToken struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRbCancelToken() *RbCancelToken {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &RbCancelToken{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Cancel all operations that use this token. Operations started with this
+// token after it has been cancelled will fail immediately with ECANCELED.
+func (token *RbCancelToken) Cancel() {
+	token.cancel()
+}
+
+func (token *RbCancelToken) IsCancelled() bool {
+	return token.ctx.Err() != nil
+}
+
+// Get the context associated with a (possibly nil) token.
+func tokenContext(token *RbCancelToken) context.Context {
+	if token == nil {
+		return context.Background()
+	}
+
+	return token.ctx
+}
+
+// Run an operation that does not accept a context, returning early if the
+// token is cancelled. rclone's VFS layer uses the context the VFS was created
+// with, so this is the only way to stop waiting for it. The operation will
+// continue running in the background and if it eventually succeeds, its result
+// is passed to abandon (if not nil) so that resources can be released.
+func runCancellable[T any](token *RbCancelToken, op func() (T, error), abandon func(T)) (T, error) {
+	var zero T
+
+	if token == nil {
+		return op()
+	} else if err := token.ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+
+	resultChan := make(chan result, 1)
+
+	go func() {
+		value, err := op()
+		resultChan <- result{value, err}
+	}()
+
+	select {
+	case r := <-resultChan:
+		return r.value, r.err
+	case <-token.ctx.Done():
+		if abandon != nil {
+			go func() {
+				r := <-resultChan
+				if r.err == nil {
+					abandon(r.value)
+				}
+			}()
+		}
+
+		return zero, token.ctx.Err()
+	}
 }
 
-// Update errOut with the specified error, translating to the nearest errno
-// equivalent if possible. If the error can't be mapped to an errno value, the
-// error code is set to the specified fallback.
+// Update errOut with the specified error. The error code is translated to the
+// nearest errno equivalent if possible and the error is classified into a
+// category that the UI can act on.
 func assignError(errOut *RbError, err error, fallback syscall.Errno) {
 	if errOut != nil {
 		errOut.Msg = err.Error()
+		assignErrorCode(errOut, err, fallback)
+		assignErrorCategory(errOut, err)
+	}
+}
 
-		errno, ok := err.(syscall.Errno)
-		if ok {
-			errOut.Code = int(errno)
-			return
-		}
-
-		switch err {
-		case vfs.ENOTEMPTY:
-			errOut.Code = int(syscall.ENOTEMPTY)
-		case vfs.ESPIPE:
-			errOut.Code = int(syscall.ESPIPE)
-		case vfs.EBADF:
-			errOut.Code = int(syscall.EBADF)
-		case vfs.EROFS:
-			errOut.Code = int(syscall.EROFS)
-		case vfs.ENOSYS:
-			errOut.Code = int(syscall.ENOSYS)
-		case vfs.ELOOP:
-			errOut.Code = int(syscall.ELOOP)
-		case vfs.ENOENT:
-			errOut.Code = int(syscall.ENOENT)
-		case vfs.EEXIST:
-			errOut.Code = int(syscall.EEXIST)
-		case vfs.EPERM:
-			errOut.Code = int(syscall.EPERM)
-		case vfs.EINVAL:
-			errOut.Code = int(syscall.EINVAL)
-		case vfs.ECLOSED:
-			errOut.Code = int(syscall.EBADF)
-		case fs.ErrorDirExists:
-			errOut.Code = int(syscall.EEXIST)
-		case fs.ErrorDirNotFound:
-			errOut.Code = int(syscall.ENOENT)
-		case fs.ErrorObjectNotFound:
-			errOut.Code = int(syscall.ENOENT)
-		case fs.ErrorIsFile:
-			errOut.Code = int(syscall.ENOTDIR)
-		case fs.ErrorIsDir:
-			errOut.Code = int(syscall.EISDIR)
-		case fs.ErrorDirectoryNotEmpty:
-			errOut.Code = int(syscall.ENOTEMPTY)
-		case fs.ErrorPermissionDenied:
-			errOut.Code = int(syscall.EACCES)
-		case fs.ErrorNotImplemented:
-			errOut.Code = int(syscall.ENOSYS)
-		case fs.ErrorCommandNotFound:
-			errOut.Code = int(syscall.ENOENT)
-		case fs.ErrorFileNameTooLong:
-			errOut.Code = int(syscall.ENAMETOOLONG)
-		case config.ErrorConfigFileNotFound:
-			errOut.Code = int(syscall.ENOENT)
-		default:
-			errOut.Code = int(fallback)
-		}
+// Set errOut's error code to the nearest errno equivalent of the error. If the
+// error can't be mapped to an errno value, the error code is set to the
+// specified fallback.
+func assignErrorCode(errOut *RbError, err error, fallback syscall.Errno) {
+	errno, ok := err.(syscall.Errno)
+	if ok {
+		errOut.Code = int(errno)
+		return
+	}
+
+	// These are frequently wrapped.
+	if errors.Is(err, context.Canceled) {
+		errOut.Code = int(syscall.ECANCELED)
+		return
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		errOut.Code = int(syscall.ETIMEDOUT)
+		return
+	}
+
+	switch err {
+	case vfs.ENOTEMPTY:
+		errOut.Code = int(syscall.ENOTEMPTY)
+	case vfs.ESPIPE:
+		errOut.Code = int(syscall.ESPIPE)
+	case vfs.EBADF:
+		errOut.Code = int(syscall.EBADF)
+	case vfs.EROFS:
+		errOut.Code = int(syscall.EROFS)
+	case vfs.ENOSYS:
+		errOut.Code = int(syscall.ENOSYS)
+	case vfs.ELOOP:
+		errOut.Code = int(syscall.ELOOP)
+	case vfs.ENOENT:
+		errOut.Code = int(syscall.ENOENT)
+	case vfs.EEXIST:
+		errOut.Code = int(syscall.EEXIST)
+	case vfs.EPERM:
+		errOut.Code = int(syscall.EPERM)
+	case vfs.EINVAL:
+		errOut.Code = int(syscall.EINVAL)
+	case vfs.ECLOSED:
+		errOut.Code = int(syscall.EBADF)
+	case fs.ErrorDirExists:
+		errOut.Code = int(syscall.EEXIST)
+	case fs.ErrorDirNotFound:
+		errOut.Code = int(syscall.ENOENT)
+	case fs.ErrorObjectNotFound:
+		errOut.Code = int(syscall.ENOENT)
+	case fs.ErrorIsFile:
+		errOut.Code = int(syscall.ENOTDIR)
+	case fs.ErrorIsDir:
+		errOut.Code = int(syscall.EISDIR)
+	case fs.ErrorDirectoryNotEmpty:
+		errOut.Code = int(syscall.ENOTEMPTY)
+	case fs.ErrorPermissionDenied:
+		errOut.Code = int(syscall.EACCES)
+	case fs.ErrorNotImplemented:
+		errOut.Code = int(syscall.ENOSYS)
+	case fs.ErrorCommandNotFound:
+		errOut.Code = int(syscall.ENOENT)
+	case fs.ErrorFileNameTooLong:
+		errOut.Code = int(syscall.ENAMETOOLONG)
+	case config.ErrorConfigFileNotFound:
+		errOut.Code = int(syscall.ENOENT)
+	default:
+		errOut.Code = int(fallback)
 	}
 }
 
@@ -388,19 +525,11 @@ func assignError(errOut *RbError, err error, fallback syscall.Errno) {
 // instances, if any, will be shut down immediately.
 func RbCacheClearRemote(remote string, deleteCacheDir bool) {
 	vfsLock.Lock()
-	defer vfsLock.Unlock()
+	state, ok := vfsStates[remote]
+	vfsLock.Unlock()
 
-	v, ok := vfsInstances[remote]
 	if ok {
-		fs.Logf(remote, "Removing from VFS cache")
-		v.Shutdown()
-
-		if deleteCacheDir {
-			fs.Logf(remote, "Deleting VFS cache directory")
-			v.CleanUp()
-		}
-
-		delete(vfsInstances, remote)
+		state.shutdown(remote, deleteCacheDir)
 	}
 
 	parsed, err := fspath.Parse(remote)
@@ -412,19 +541,8 @@ func RbCacheClearRemote(remote string, deleteCacheDir bool) {
 // Clear cached fs and vfs instances. All vfs instances will be shut down
 // immediately.
 func RbCacheClearAll(deleteCacheDir bool) {
-	vfsLock.Lock()
-	defer vfsLock.Unlock()
-
-	for k, vfs := range vfsInstances {
-		fs.Logf(k, "Removing from VFS cache")
-		vfs.Shutdown()
-
-		if deleteCacheDir {
-			fs.Logf(k, "Deleting VFS cache directory")
-			vfs.CleanUp()
-		}
-
-		delete(vfsInstances, k)
+	for remote, state := range getVfsStates() {
+		state.shutdown(remote, deleteCacheDir)
 	}
 
 	cache.Clear()
@@ -433,14 +551,15 @@ func RbCacheClearAll(deleteCacheDir bool) {
 // The number of seconds to wait before all VFS instances with caching enabled
 // have begun one cleanup cycle if all of their timers were to start now.
 func RbCacheCleanupMaxWaitSeconds() int64 {
-	vfsLock.Lock()
-	defer vfsLock.Unlock()
-
 	maxWait := fs.Duration(0)
 
-	for _, vfs := range vfsInstances {
-		if vfs.Opt.CacheMode != vfscommon.CacheModeOff {
-			maxWait = max(maxWait, vfs.Opt.CacheMaxAge+vfs.Opt.CachePollInterval)
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	for _, state := range getVfsStates() {
+		v := state.v.Load()
+		if v != nil && v.Opt.CacheMode != vfscommon.CacheModeOff {
+			maxWait = max(maxWait, state.cacheMaxAge+v.Opt.CachePollInterval)
 		}
 	}
 
@@ -490,13 +609,19 @@ func RbConfigClearPassword() {
 	config.ClearConfigPassword()
 }
 
-func RbConfigLoad(deleteCacheDir bool, errOut *RbError) bool {
+// Load the config file and shut down the fs and vfs instances of the remotes
+// whose config sections were added, removed, or changed. Instances of other
+// remotes, along with their in-progress uploads, are left alone. Returns the
+// names of the changed sections.
+func RbConfigLoad(deleteCacheDir bool, errOut *RbError) *RbStringList {
+	oldSections := getSections()
+
 	// We explicitly call this instead of config.LoadedData() so that errors can
 	// be reported
 	err := config.Data().Load()
 	if err != nil {
 		assignError(errOut, err, syscall.EIO)
-		return false
+		return nil
 	}
 
 	// Migrate the legacy VFS caching option to the new custom VFS options map.
@@ -506,7 +631,7 @@ func RbConfigLoad(deleteCacheDir bool, errOut *RbError) bool {
 			isCaching, err := strconv.ParseBool(value)
 			if err != nil {
 				assignError(errOut, err, syscall.EINVAL)
-				return false
+				return nil
 			}
 
 			var vfsCacheMode vfscommon.CacheMode
@@ -521,9 +646,25 @@ func RbConfigLoad(deleteCacheDir bool, errOut *RbError) bool {
 		}
 	}
 
-	RbCacheClearAll(deleteCacheDir)
+	newSections := getSections()
+	changed := diffSections(oldSections, newSections)
 
-	return true
+	for _, name := range changed {
+		fs.Logf(nil, "Config section changed: %q", name)
+		shutdownSection(name, deleteCacheDir)
+
+		// Shutting down only deletes the cache directories of VFS instances
+		// that exist, but the cache may have been left behind by earlier runs.
+		if _, ok := newSections[name]; !ok && deleteCacheDir {
+			for _, dir := range getVfsCacheDirs(name) {
+				if err := os.RemoveAll(dir); err != nil {
+					fs.Logf(nil, "Failed to delete VFS cache directory: %q: %v", dir, err)
+				}
+			}
+		}
+	}
+
+	return &RbStringList{items: changed}
 }
 
 func RbConfigSave(errOut *RbError) bool {
@@ -573,7 +714,7 @@ func getFs(remote string) (fs.Fs, error) {
 // Note that this intentionally does not cache the fs instance because unless it
 // points to a root, file operations may invalidate it (eg. a directory is
 // deleted and a file is created in its place).
-func getFsForDoc(doc string, treatAsFile bool) (fs.Fs, string, error) {
+func getFsForDoc(ctx context.Context, doc string, treatAsFile bool) (fs.Fs, string, error) {
 	parent, name, err := fspath.Split(doc)
 	if err != nil {
 		return nil, "", err
@@ -589,7 +730,7 @@ func getFsForDoc(doc string, treatAsFile bool) (fs.Fs, string, error) {
 		remote = parent
 	}
 
-	f, err := fs.NewFs(context.Background(), remote)
+	f, err := fs.NewFs(ctx, remote)
 	if !treatAsFile && err == fs.ErrorIsFile {
 		return f, name, nil
 	} else if err != nil {
@@ -638,14 +779,6 @@ func getVfsOverrides(remote string) (vfsOverrides, error) {
 func getVfsOpts(overrides vfsOverrides) (vfscommon.Options, error) {
 	opts := vfscommon.Opt
 
-	// Significantly shorten the time that directory entries are cached so that
-	// file listings are more likely to reflect reality after external file
-	// operations made outside of rclone or local operations that touch the
-	// backend directly, like copying/moving. There's no public nor internal API
-	// for just invalidating the directory cache. The RC API has vfs/refresh but
-	// that forces an unnecessary reread of directories.
-	opts.DirCacheTime = fs.Duration(5 * time.Second)
-
 	// Required for O_RDWR.
 	opts.CacheMode = vfscommon.CacheModeWrites
 
@@ -751,38 +884,157 @@ func RbVfsGetOpts(overrides *RbVfsOptList, errOut *RbError) *RbVfsOptList {
 // writes to disk in order to allow opening files for both reading and writing
 // at the same time.
 func getVfs(remote string) (*vfs.VFS, error) {
+	for {
+		state := getVfsState(remote)
+		if v := state.v.Load(); v != nil {
+			return v, nil
+		}
+
+		v, err := state.create(remote)
+		if err == errVfsStateRemoved {
+			// The VFS was shut down while we were waiting for the lock.
+			continue
+		}
+
+		return v, err
+	}
+}
+
+// Create the VFS if it does not already exist. Fails with errVfsStateRemoved if
+// the state was removed from vfsStates while waiting for the lock.
+func (state *vfsState) create(remote string) (*vfs.VFS, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if state.removed {
+		return nil, errVfsStateRemoved
+	}
+
+	// Another thread may have created the VFS while we were waiting.
+	if v := state.v.Load(); v != nil {
+		return v, nil
+	}
+
+	// This must happen while holding the lock so that the VFS is never created
+	// with an fs that was cleared from the cache by a concurrent shutdown.
 	f, err := getFs(remote)
 	if err != nil {
 		return nil, err
 	}
 
+	fs.Logf(remote, "Creating new VFS instance")
+
+	overrides, err := getVfsOverrides(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := getVfsOpts(overrides)
+	if err != nil {
+		fs.Logf(remote, "Failed to apply VFS options overrides: %+v", overrides)
+		return nil, err
+	}
+
+	// Changes made outside of rclone can only be detected with ChangeNotify.
+	// For backends that don't support it, significantly shorten the time that
+	// directory entries are cached so that file listings are more likely to
+	// reflect reality, unless the user chose a different value.
+	if _, ok := overrides["dir_cache_time"]; !ok && f.Features().ChangeNotify == nil {
+		opts.DirCacheTime = fs.Duration(5 * time.Second)
+	}
+
+	ctx, err := newVfsContext(remote)
+	if err != nil {
+		fs.Logf(remote, "Failed to apply bandwidth limit override: %v", err)
+		return nil, err
+	}
+
+	hookChangeNotify(remote, f)
+
+	v := vfs.New(ctx, f, &opts)
+
+	// Make Close() synchronous again because we rely on this for the in-use
+	// file tracker in RcloneProvider and also for upload error reporting.
+	v.Opt.WriteBack = 0
+
+	networkLock.Lock()
+	state.cacheMaxAge = v.Opt.CacheMaxAge
+	state.v.Store(v)
+	applyNetworkState(remote, state)
+	networkLock.Unlock()
+
+	return v, nil
+}
+
+// Get the vfs instance for the given remote without creating it. Fails with
+// ENOENT if it does not exist.
+func getExistingVfs(remote string) (*vfs.VFS, error) {
+	vfsLock.Lock()
+	state, ok := vfsStates[remote]
+	vfsLock.Unlock()
+
+	if ok {
+		if v := state.v.Load(); v != nil {
+			return v, nil
+		}
+	}
+
+	return nil, syscall.ENOENT
+}
+
+// Get the VFS state for the given remote, creating it if needed.
+func getVfsState(remote string) *vfsState {
 	vfsLock.Lock()
 	defer vfsLock.Unlock()
 
-	v, ok := vfsInstances[remote]
+	state, ok := vfsStates[remote]
 	if !ok {
-		fs.Logf(remote, "Creating new VFS instance")
+		state = &vfsState{}
+		vfsStates[remote] = state
+	}
 
-		overrides, err := getVfsOverrides(remote)
-		if err != nil {
-			return nil, err
-		}
+	return state
+}
 
-		opts, err := getVfsOpts(overrides)
-		if err != nil {
-			fs.Logf(remote, "Failed to apply VFS options overrides: %+v", overrides)
-			return nil, err
+// Get a snapshot of the VFS states for all remotes.
+func getVfsStates() map[string]*vfsState {
+	vfsLock.Lock()
+	defer vfsLock.Unlock()
+
+	return maps.Clone(vfsStates)
+}
+
+// Shut down the VFS if it exists and remove the state from vfsStates. This
+// waits for the VFS to finish being created if another thread is in the process
+// of doing so.
+func (state *vfsState) shutdown(remote string, deleteCacheDir bool) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if !state.removed {
+		state.removed = true
+
+		vfsLock.Lock()
+		if vfsStates[remote] == state {
+			delete(vfsStates, remote)
 		}
+		vfsLock.Unlock()
+	}
 
-		v = vfs.New(context.Background(), f, &opts)
-		vfsInstances[remote] = v
+	v := state.v.Swap(nil)
+	if v == nil {
+		return
+	}
+
+	fs.Logf(remote, "Removing from VFS cache")
+	v.Shutdown()
 
-		// Make Close() synchronous again because we rely on this for the in-use
-		// file tracker in RcloneProvider and also for upload error reporting.
-		v.Opt.WriteBack = 0
+	if deleteCacheDir {
+		fs.Logf(remote, "Deleting VFS cache directory")
+		v.CleanUp()
 	}
 
-	return v, nil
+	unhookChangeNotify(v.Fs())
 }
 
 // Create a vfs instance for the given document or get it from the cache if it
@@ -802,10 +1054,29 @@ func getVfsForDoc(doc string) (*vfs.VFS, string, error) {
 	return v, path, nil
 }
 
+type RbStringList struct {
+	items []string
+}
+
+func (list *RbStringList) Add(item string) {
+	list.items = append(list.items, item)
+}
+
+func (list *RbStringList) Get(index int) string {
+	return list.items[index]
+}
+
+func (list *RbStringList) Size() int {
+	return len(list.items)
+}
+
 type RbRemoteFeaturesResult struct {
-	Copy  bool
-	Move  bool
-	About bool
+	Copy       bool
+	Move       bool
+	About      bool
+	PublicLink bool
+	// Names of the hash types supported by the remote, like "md5".
+	Hashes *RbStringList
 }
 
 // Return supported features about the specified remote.
@@ -816,17 +1087,87 @@ func RbRemoteFeatures(remote string, errOut *RbError) *RbRemoteFeaturesResult {
 		return nil
 	}
 
+	return newRemoteFeaturesResult(f)
+}
+
+func newRemoteFeaturesResult(f fs.Fs) *RbRemoteFeaturesResult {
 	features := f.Features()
 
+	hashes := []string{}
+	for _, hashType := range f.Hashes().Array() {
+		hashes = append(hashes, hashType.String())
+	}
+
 	result := RbRemoteFeaturesResult{
-		Copy:  features.Copy != nil,
-		Move:  features.Move != nil,
-		About: features.About != nil,
+		Copy:       features.Copy != nil,
+		Move:       features.Move != nil,
+		About:      features.About != nil,
+		PublicLink: features.PublicLink != nil,
+		Hashes:     &RbStringList{items: hashes},
 	}
 
 	return &result
 }
 
+type RbRemoteAboutResult struct {
+	// Total size of the remote in bytes.
+	Total int64
+	// Bytes in use.
+	Used int64
+	// Bytes in the trash.
+	Trashed int64
+	// Bytes in use by other things, like other services sharing the quota.
+	Other int64
+	// Bytes available for use.
+	Free int64
+	// Number of objects stored on the remote.
+	Objects int64
+}
+
+// Get the storage usage of the specified remote. Each field is set to -1 if the
+// remote does not report it. Fails with EOPNOTSUPP if the remote does not
+// support querying the usage at all.
+func RbRemoteAbout(remote string, token *RbCancelToken, errOut *RbError) *RbRemoteAboutResult {
+	if err := checkNetwork(remote); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return nil
+	}
+
+	f, err := getFs(remote)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	doAbout := f.Features().About
+	if doAbout == nil {
+		assignError(errOut, syscall.EOPNOTSUPP, syscall.EOPNOTSUPP)
+		return nil
+	}
+
+	usage, err := doAbout(tokenContext(token))
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	valueOrUnknown := func(value *int64) int64 {
+		if value == nil {
+			return -1
+		}
+		return *value
+	}
+
+	return &RbRemoteAboutResult{
+		Total:   valueOrUnknown(usage.Total),
+		Used:    valueOrUnknown(usage.Used),
+		Trashed: valueOrUnknown(usage.Trashed),
+		Other:   valueOrUnknown(usage.Other),
+		Free:    valueOrUnknown(usage.Free),
+		Objects: valueOrUnknown(usage.Objects),
+	}
+}
+
 type RbRemoteSplitResult struct {
 	Remote string
 	Path   string
@@ -907,12 +1248,43 @@ func readDir(vfs *vfs.VFS, path string) ([]os.FileInfo, error) {
 	return fis, nil
 }
 
+type RbKeyValue struct {
+	Key   string
+	Value string
+}
+
+type RbKeyValueList struct {
+	items []RbKeyValue
+}
+
+func (list *RbKeyValueList) Get(index int) *RbKeyValue {
+	return &list.items[index]
+}
+
+func (list *RbKeyValueList) Size() int {
+	return len(list.items)
+}
+
 type RbDirEntry struct {
 	Doc     string
 	Name    string
 	Size    int64
 	Mode    int
 	ModTime int64
+	// MIME type reported by the backend or guessed from the file extension.
+	// This is "inode/directory" for directories.
+	MimeType string
+	// The backend's ID for the file or directory or an empty string if the
+	// backend does not have IDs.
+	Id string
+	// Hashes that the backend already knows, keyed by hash type name. This is
+	// only populated by RbDocStat() when detailed is true and is always empty
+	// for backends where hashes need to be computed.
+	Hashes *RbKeyValueList
+	// Backend metadata. This is only populated by RbDocStat() when detailed
+	// is true because reading the metadata may require a separate request for
+	// each file.
+	Metadata *RbKeyValueList
 }
 
 type RbDirEntryList struct {
@@ -950,31 +1322,92 @@ func fileModeToStatMode(mode os.FileMode) (result int) {
 	return result
 }
 
-func newDirEntry(fi os.FileInfo, doc string, docIsParent bool) RbDirEntry {
+// Fill in the details that come from the backend's directory entry, which is
+// nil if the file has not been uploaded yet. Anything that may require extra
+// requests for each file is only queried if detailed is true.
+func (entry *RbDirEntry) fillDetails(ctx context.Context, f fs.Fs, dirEntry fs.DirEntry, detailed bool) {
+	features := f.Features()
+	hashes := []RbKeyValue{}
+	metadata := []RbKeyValue{}
+
+	if dirEntry == nil {
+		if entry.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+			entry.MimeType = "inode/directory"
+		} else {
+			entry.MimeType = fs.MimeTypeFromName(entry.Name)
+		}
+	} else if _, ok := dirEntry.(fs.Object); ok && features.SlowModTime && !detailed {
+		// Backends, like S3, that need a separate request to get the
+		// modification time need the same request to get the MIME type.
+		entry.MimeType = fs.MimeTypeFromName(entry.Name)
+	} else {
+		entry.MimeType = fs.MimeTypeDirEntry(ctx, dirEntry)
+	}
+
+	if ider, ok := dirEntry.(fs.IDer); ok {
+		entry.Id = ider.ID()
+	}
+
+	// Backends with SlowHash set may read the entire file to compute hashes.
+	// Others, like S3 for multipart uploads, may still need a separate request
+	// for each file.
+	if obj, ok := dirEntry.(fs.Object); ok && !features.SlowHash && detailed {
+		for _, ht := range f.Hashes().Array() {
+			sum, err := obj.Hash(ctx, ht)
+			if err == nil && sum != "" {
+				hashes = append(hashes, RbKeyValue{Key: ht.String(), Value: sum})
+			}
+		}
+	}
+
+	if dirEntry != nil && detailed {
+		m, err := fs.GetMetadata(ctx, dirEntry)
+		if err != nil {
+			fs.Logf(dirEntry, "Failed to get metadata: %v", err)
+		}
+
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			metadata = append(metadata, RbKeyValue{Key: k, Value: m[k]})
+		}
+	}
+
+	entry.Hashes = &RbKeyValueList{items: hashes}
+	entry.Metadata = &RbKeyValueList{items: metadata}
+}
+
+func newDirEntry(ctx context.Context, node vfs.Node, doc string, docIsParent bool, detailed bool) RbDirEntry {
 	entryDoc := doc
 	if docIsParent {
-		entryDoc = fspath.JoinRootPath(doc, fi.Name())
+		entryDoc = fspath.JoinRootPath(doc, node.Name())
 	}
 
-	return RbDirEntry{
+	entry := RbDirEntry{
 		Doc:     entryDoc,
-		Name:    fi.Name(),
-		Size:    fi.Size(),
-		Mode:    fileModeToStatMode(fi.Mode()),
-		ModTime: fi.ModTime().UnixMilli(),
+		Name:    node.Name(),
+		Size:    node.Size(),
+		Mode:    fileModeToStatMode(node.Mode()),
+		ModTime: node.ModTime().UnixMilli(),
 	}
+
+	entry.fillDetails(ctx, node.VFS().Fs(), node.DirEntry(), detailed)
+
+	return entry
 }
 
 // List the contents of a directory. The entries are sorted lexicographically by
 // name.
-func RbDocListDir(doc string, errOut *RbError) *RbDirEntryList {
+func RbDocListDir(doc string, token *RbCancelToken, errOut *RbError) *RbDirEntryList {
 	v, path, err := getVfsForDoc(doc)
 	if err != nil {
 		assignError(errOut, err, syscall.EINVAL)
 		return nil
 	}
 
-	fis, err := readDir(v, path)
+	ctx := tokenContext(token)
+
+	fis, err := runCancellable(token, func() ([]os.FileInfo, error) {
+		return readDir(v, path)
+	}, nil)
 	if err != nil {
 		assignError(errOut, err, syscall.EIO)
 		return nil
@@ -983,33 +1416,151 @@ func RbDocListDir(doc string, errOut *RbError) *RbDirEntryList {
 	entries := []RbDirEntry{}
 
 	for _, fi := range fis {
-		entry := newDirEntry(fi, doc, true)
+		// vfs.Dir.Readdir() only returns nodes.
+		entry := newDirEntry(ctx, fi.(vfs.Node), doc, true, false)
 		entries = append(entries, entry)
 	}
 
 	return &RbDirEntryList{items: entries}
 }
 
-// Stat a single document without following symlinks.
-func RbDocStat(doc string, errOut *RbError) *RbDirEntry {
+// Stat a single document without following symlinks. If detailed is true, the
+// backend metadata and the MIME type are queried too, which may require
+// additional requests to the backend.
+func RbDocStat(doc string, detailed bool, token *RbCancelToken, errOut *RbError) *RbDirEntry {
 	v, path, err := getVfsForDoc(doc)
 	if err != nil {
 		assignError(errOut, err, syscall.EINVAL)
 		return nil
 	}
 
-	fi, err := v.Stat(path)
+	ctx := tokenContext(token)
+
+	entry, err := runCancellable(token, func() (RbDirEntry, error) {
+		node, err := v.Stat(path)
+		if err != nil {
+			return RbDirEntry{}, err
+		}
+
+		return newDirEntry(ctx, node, doc, false, detailed), nil
+	}, nil)
 	if err != nil {
 		assignError(errOut, err, syscall.EIO)
 		return nil
 	}
 
-	entry := newDirEntry(fi, doc, false)
 	return &entry
 }
 
+type RbDocHashResult struct {
+	// The hex-encoded hash or an empty string if the backend does not know the
+	// hash for this particular file.
+	Hash string
+}
+
+// Get a file's hash from the backend. The hash type is a name reported by
+// RbRemoteFeatures(), like "md5". This never computes the hash by downloading
+// the file. Fails with EOPNOTSUPP if the remote does not support the hash type.
+func RbDocHash(doc string, hashType string, token *RbCancelToken, errOut *RbError) *RbDocHashResult {
+	var ht hash.Type
+	if err := ht.Set(hashType); err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	if err := checkNetworkForDoc(doc); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return nil
+	}
+
+	v, path, err := getVfsForDoc(doc)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	} else if path == "" {
+		assignError(errOut, fs.ErrorIsDir, syscall.EISDIR)
+		return nil
+	}
+
+	f := v.Fs()
+	if !f.Hashes().Contains(ht) {
+		assignError(errOut, hash.ErrUnsupported, syscall.EOPNOTSUPP)
+		return nil
+	}
+
+	ctx := tokenContext(token)
+
+	obj, err := f.NewObject(ctx, path)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	sum, err := obj.Hash(ctx, ht)
+	if err == hash.ErrUnsupported {
+		assignError(errOut, err, syscall.EOPNOTSUPP)
+		return nil
+	} else if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	return &RbDocHashResult{
+		Hash: sum,
+	}
+}
+
+type RbDocPublicLinkResult struct {
+	// The public link or an empty string if the link was removed.
+	Url string
+}
+
+// Create a link that allows anyone to access the document without
+// authentication. The link expires after expirySeconds if it is greater than 0
+// and if the backend supports expiry. If unlink is true, the existing link is
+// removed instead. Fails with EOPNOTSUPP if the remote does not support public
+// links.
+func RbDocPublicLink(doc string, expirySeconds int64, unlink bool, token *RbCancelToken, errOut *RbError) *RbDocPublicLinkResult {
+	if err := checkNetworkForDoc(doc); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return nil
+	}
+
+	v, path, err := getVfsForDoc(doc)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	f := v.Fs()
+	if f.Features().PublicLink == nil {
+		assignError(errOut, syscall.EOPNOTSUPP, syscall.EOPNOTSUPP)
+		return nil
+	}
+
+	expiry := fs.DurationOff
+	if expirySeconds > 0 {
+		expiry = fs.Duration(time.Duration(expirySeconds) * time.Second)
+	}
+
+	url, err := operations.PublicLink(tokenContext(token), f, path, expiry, unlink)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	return &RbDocPublicLinkResult{
+		Url: url,
+	}
+}
+
 // Create a directory with the specified permissions.
 func RbDocMkdir(doc string, perms int, errOut *RbError) bool {
+	if err := checkNetworkForDoc(doc); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return false
+	}
+
 	v, path, err := getVfsForDoc(doc)
 	if err != nil {
 		assignError(errOut, err, syscall.EINVAL)
@@ -1046,6 +1597,11 @@ func RbDocMkdir(doc string, perms int, errOut *RbError) bool {
 // heavy use of custom (string) errors. Aside from EEXIST, errors cannot be
 // relied on for making decisions (eg. for TOCTOU avoidance).
 func RbDocRename(sourceDoc string, targetDoc string, errOut *RbError) bool {
+	if err := checkNetworkForDoc(sourceDoc); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return false
+	}
+
 	sourceVfs, sourcePath, err := getVfsForDoc(sourceDoc)
 	if err != nil {
 		assignError(errOut, err, syscall.EINVAL)
@@ -1073,6 +1629,11 @@ func RbDocRename(sourceDoc string, targetDoc string, errOut *RbError) bool {
 
 // Delete a document (optionally recursively).
 func RbDocRemove(doc string, recurse bool, errOut *RbError) bool {
+	if err := checkNetworkForDoc(doc); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return false
+	}
+
 	v, path, err := getVfsForDoc(doc)
 	if err != nil {
 		assignError(errOut, err, syscall.EINVAL)
@@ -1099,15 +1660,21 @@ func RbDocRemove(doc string, recurse bool, errOut *RbError) bool {
 	return true
 }
 
-// Copy or move a document. If the target exists, its type (directory or not)
-// much match the type of the source. If the documents are directories, then the
-// contents are copied/moved. In other words, the source directory's name is not
-// added as a path element in the target. If a target file already exists, it
-// will be overwritten.
-//
-// This uses server-side copying/moving if it's supported by the remote backend.
-// Otherwise, it falls back to downloading and reuploading the data.
-func RbDocCopyOrMove(sourceDoc string, targetDoc string, copy bool, errOut *RbError) bool {
+// Copy or move a document. On failure, the errno to fall back to if the error
+// can't be mapped is returned along with the error. See RbDocCopyOrMove() for
+// details.
+func copyOrMove(ctx context.Context, sourceDoc string, targetDoc string, copy bool) (syscall.Errno, error) {
+	if err := checkNetworkForDoc(sourceDoc); err != nil {
+		return syscall.ENETUNREACH, err
+	} else if err := checkNetworkForDoc(targetDoc); err != nil {
+		return syscall.ENETUNREACH, err
+	}
+
+	ctx, err := withBwLimitFile(ctx, sourceDoc, targetDoc)
+	if err != nil {
+		return syscall.EINVAL, err
+	}
+
 	// If a document exists and is a file, then fs points to its parent
 	// directory and the filename is the document's filename. Otherwise, the fs
 	// points to the document directly and the filename is empty. This means we
@@ -1115,22 +1682,19 @@ func RbDocCopyOrMove(sourceDoc string, targetDoc string, copy bool, errOut *RbEr
 	// We'll just follow the behavior of rclone's copyto/moveto in assuming that
 	// the source document exists and use filename == "" to decide which code
 	// path to take.
-	sourceFs, sourceFile, err := getFsForDoc(sourceDoc, false)
+	sourceFs, sourceFile, err := getFsForDoc(ctx, sourceDoc, false)
 	if err != nil {
-		assignError(errOut, err, syscall.EINVAL)
-		return false
+		return syscall.EINVAL, err
 	}
 
 	// If the source is a file, we want avoid rclone's behavior described above
 	// and make targetFs point to the parent and targetFile to the filename.
-	targetFs, targetFile, err := getFsForDoc(targetDoc, sourceFile != "")
+	targetFs, targetFile, err := getFsForDoc(ctx, targetDoc, sourceFile != "")
 	if err != nil {
-		assignError(errOut, err, syscall.EINVAL)
-		return false
+		return syscall.EINVAL, err
 	}
 
 	var opErr error
-	ctx := context.Background()
 
 	if sourceFile == "" {
 		if targetFile != "" {
@@ -1153,16 +1717,14 @@ func RbDocCopyOrMove(sourceDoc string, targetDoc string, copy bool, errOut *RbEr
 	} else {
 		sourceObj, err := sourceFs.NewObject(ctx, sourceFile)
 		if err != nil {
-			assignError(errOut, err, syscall.EIO)
-			return false
+			return syscall.EIO, err
 		}
 
 		targetObj, err := targetFs.NewObject(ctx, targetFile)
 		if err == fs.ErrorObjectNotFound {
 			targetObj = nil
 		} else if err != nil {
-			assignError(errOut, err, syscall.EIO)
-			return false
+			return syscall.EIO, err
 		}
 
 		operation := operations.Move
@@ -1173,8 +1735,93 @@ func RbDocCopyOrMove(sourceDoc string, targetDoc string, copy bool, errOut *RbEr
 		_, opErr = operation(ctx, targetFs, targetObj, targetFile, sourceObj)
 	}
 
+	// The backend was modified directly, so the VFS doesn't know about the
+	// changes. This is done even on failure because some files may have been
+	// transferred.
+	if err := invalidateDoc(targetDoc, true); err != nil {
+		fs.Logf(targetDoc, "Failed to invalidate directory cache: %v", err)
+	}
+	if !copy {
+		if err := invalidateDoc(sourceDoc, true); err != nil {
+			fs.Logf(sourceDoc, "Failed to invalidate directory cache: %v", err)
+		}
+	}
+
 	if opErr != nil {
-		assignError(errOut, opErr, syscall.EIO)
+		return syscall.EIO, opErr
+	}
+
+	return 0, nil
+}
+
+// Copy or move a document. If the target exists, its type (directory or not)
+// much match the type of the source. If the documents are directories, then the
+// contents are copied/moved. In other words, the source directory's name is not
+// added as a path element in the target. If a target file already exists, it
+// will be overwritten.
+//
+// This uses server-side copying/moving if it's supported by the remote backend.
+// Otherwise, it falls back to downloading and reuploading the data.
+func RbDocCopyOrMove(sourceDoc string, targetDoc string, copy bool, token *RbCancelToken, errOut *RbError) bool {
+	fallback, err := copyOrMove(tokenContext(token), sourceDoc, targetDoc, copy)
+	if err != nil {
+		assignError(errOut, err, fallback)
+		return false
+	}
+
+	return true
+}
+
+// Forget the cached directory entries for a document in its remote's existing
+// VFS instance. Nothing is reread until the next time the entries are needed.
+// This does nothing if the VFS has not been created.
+func invalidateDoc(doc string, recursive bool) error {
+	remote, docPath, err := fspath.SplitFs(doc)
+	if err != nil {
+		return err
+	}
+
+	v, err := getExistingVfs(remote)
+	if err == syscall.ENOENT {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	root, err := v.Root()
+	if err != nil {
+		return err
+	}
+
+	docPath = strings.Trim(docPath, "/")
+
+	if recursive {
+		// This marks the parent directory as stale and forgets everything
+		// cached under the document if it's a directory.
+		root.ForgetPath(docPath, fs.EntryDirectory)
+	} else {
+		// This marks the parent directory as stale.
+		root.ForgetPath(docPath, fs.EntryObject)
+		// There's no API for marking a directory as stale without forgetting
+		// its children, so do the same thing for a (possibly nonexistent)
+		// child. This does nothing if the document is not a directory.
+		root.ForgetPath(path.Join(docPath, "_"), fs.EntryObject)
+	}
+
+	notifyChange(remote, docPath, fs.EntryDirectory)
+
+	return nil
+}
+
+// Forget the cached directory entries for a document so that changes made
+// outside of the VFS, like by another rclone instance, become visible. If the
+// document is a directory, its listing is reread the next time it's accessed.
+// If recursive is true, all cached entries under the directory are forgotten
+// too.
+func RbDocInvalidate(doc string, recursive bool, errOut *RbError) bool {
+	err := invalidateDoc(doc, recursive)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
 		return false
 	}
 
@@ -1182,13 +1829,19 @@ func RbDocCopyOrMove(sourceDoc string, targetDoc string, copy bool, errOut *RbEr
 }
 
 type RbFile struct {
-	file            vfs.Handle
+	file vfs.Handle
+	// The VFS that the file was opened from or nil if the file was closed.
+	v               *vfs.VFS
+	writable        bool
 	nonCachingWrite bool
 	flushed         bool
+	// Reusable buffer for cancellable reads. See ReadAt().
+	readBufLock goSync.Mutex
+	readBuf     []byte
 }
 
 // Open a file in the VFS at the given path. This works like POSIX open().
-func RbDocOpen(doc string, flags int, mode int, errOut *RbError) *RbFile {
+func RbDocOpen(doc string, flags int, mode int, token *RbCancelToken, errOut *RbError) *RbFile {
 	v, path, err := getVfsForDoc(doc)
 	if err != nil {
 		assignError(errOut, err, syscall.EINVAL)
@@ -1210,7 +1863,9 @@ func RbDocOpen(doc string, flags int, mode int, errOut *RbError) *RbFile {
 		// but that only happens via openRW() when caching is enabled, not with
 		// openWrite().
 		if flags&os.O_CREATE != 0 && flags&os.O_EXCL != 0 {
-			fi, err := v.Stat(path)
+			fi, err := runCancellable(token, func() (vfs.Node, error) {
+				return v.Stat(path)
+			}, nil)
 			if err != nil {
 				if err != vfs.ENOENT {
 					assignError(errOut, err, syscall.EIO)
@@ -1226,14 +1881,22 @@ func RbDocOpen(doc string, flags int, mode int, errOut *RbError) *RbFile {
 		}
 	}
 
-	handle, err := v.OpenFile(path, flags, ioFs.FileMode(mode&int(ioFs.ModePerm)))
+	handle, err := runCancellable(token, func() (vfs.Handle, error) {
+		return v.OpenFile(path, flags, ioFs.FileMode(mode&int(ioFs.ModePerm)))
+	}, func(handle vfs.Handle) {
+		handle.Close()
+	})
 	if err != nil {
 		assignError(errOut, err, syscall.EIO)
 		return nil
 	}
 
+	addOpenFile(v, 1)
+
 	return &RbFile{
 		file:            handle,
+		v:               v,
+		writable:        flags&(os.O_WRONLY|os.O_RDWR) != 0,
 		nonCachingWrite: nonCachingWrite,
 		flushed:         false,
 	}
@@ -1243,7 +1906,19 @@ func RbDocOpen(doc string, flags int, mode int, errOut *RbError) *RbFile {
 //
 // Even if an error is returned, the file handle should be considered closed.
 func (rbfile *RbFile) Close(errOut *RbError) bool {
+	v := rbfile.v
+	if v != nil {
+		addOpenFile(v, -1)
+		rbfile.v = nil
+	}
+
 	err := rbfile.file.Close()
+
+	if v != nil && rbfile.writable {
+		// Closing may have queued the file for upload.
+		repauseUploads(v)
+	}
+
 	// WriteFileHandle's Flush() method calls the internal close() method, which
 	// can only be done once.
 	if err != nil && !(err == vfs.ECLOSED && rbfile.nonCachingWrite && rbfile.flushed) {
@@ -1260,16 +1935,61 @@ func (rbfile *RbFile) Close(errOut *RbError) bool {
 // On success, the number of bytes read is returned. When EOF is reached, the
 // number of bytes read may be less than the size requested. If an error occurs,
 // then -1 is returned.
-func (rbfile *RbFile) ReadAt(data []byte, size int, offset int64, errOut *RbError) int {
-	n, err := rbfile.file.ReadAt(data[:size], offset)
-	if err != nil && err != io.EOF {
+func (rbfile *RbFile) ReadAt(data []byte, size int, offset int64, token *RbCancelToken, errOut *RbError) int {
+	buf := data[:size]
+	if token != nil {
+		// If the read is abandoned, it must not write to the caller's buffer
+		// after we return, so read into a buffer owned by the handle instead.
+		buf = rbfile.takeReadBuf(size)
+	}
+
+	n, err := runCancellable(token, func() (int, error) {
+		n, err := rbfile.file.ReadAt(buf, offset)
+		if err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}, nil)
+	if err != nil {
+		// The read may have been abandoned and still be using the buffer.
 		assignError(errOut, err, syscall.EIO)
 		return -1
 	}
 
+	if token != nil {
+		copy(data, buf[:n])
+		rbfile.putReadBuf(buf)
+	}
+
 	return n
 }
 
+// Take the handle's read buffer, allocating a new one if it is too small or is
+// in use by another read.
+func (rbfile *RbFile) takeReadBuf(size int) []byte {
+	rbfile.readBufLock.Lock()
+	defer rbfile.readBufLock.Unlock()
+
+	buf := rbfile.readBuf
+	rbfile.readBuf = nil
+
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+
+	return buf[:size]
+}
+
+// Return a buffer obtained from takeReadBuf() so that later reads can reuse it.
+func (rbfile *RbFile) putReadBuf(buf []byte) {
+	rbfile.readBufLock.Lock()
+	defer rbfile.readBufLock.Unlock()
+
+	if cap(buf) > cap(rbfile.readBuf) {
+		rbfile.readBuf = buf
+	}
+}
+
 // Write to the file handle at the specified offset. This works like Linux's
 // pwrite().
 //
@@ -1300,6 +2020,37 @@ func (rbfile *RbFile) Flush(errOut *RbError) bool {
 	return true
 }
 
+// Truncate or extend the file to the specified size. This works like POSIX
+// ftruncate().
+//
+// This is not reachable by client apps. Android's ProxyFileDescriptorCallback
+// has no truncate callback, so ftruncate() on a file descriptor opened via
+// RcloneProvider still fails. This is only for the host's own use.
+//
+// If the VFS cache mode is lower than writes, the file is being streamed to the
+// backend, so the size can't be changed. Only truncating to the current size is
+// allowed and anything else fails with EOPNOTSUPP.
+func (rbfile *RbFile) Truncate(size int64, errOut *RbError) bool {
+	if size < 0 || !rbfile.writable {
+		assignError(errOut, syscall.EINVAL, syscall.EINVAL)
+		return false
+	}
+
+	err := rbfile.file.Truncate(size)
+	if err != nil {
+		if rbfile.nonCachingWrite && err == vfs.EPERM {
+			// WriteFileHandle reports EPERM, which is misleading since it has
+			// nothing to do with permissions.
+			err = syscall.EOPNOTSUPP
+		}
+
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	return true
+}
+
 // Stat the file and get the file size. Returns -1 if an error occurs.
 func (rbfile *RbFile) GetSize(errOut *RbError) int64 {
 	fi, err := rbfile.file.Stat()
@@ -1311,47 +2062,199 @@ func (rbfile *RbFile) GetSize(errOut *RbError) int64 {
 	return fi.Size()
 }
 
-// The internal API for `rclone authorize` is not suitable for library usage.
-// None of the underlying functions are available outside of the oauthutil
-// package and the higher level functions print to stdout and can only be killed
-// by sending a bad request to it. But since that's what we have to work with,
-// we'll deal with it.
-//
-// There are two pieces of information we need: the local server URL and the
-// oauth refresh token once authorized. The former can only be obtained via
-// logcat since oauthutil.configSetup() uses fs.Logf(). The latter gets printed
-// to stdout, which normally shows up in logcat due to gomobile, but some OS's
-// like HarmonyOS only show messages with error priority. We work around this by
-// temporarily sending stdout to stderr.
-func RbAuthorize(argsNullSep string, errOut *RbError) bool {
-	stdoutHijackLock.Lock()
-	defer stdoutHijackLock.Unlock()
-
-	var args = strings.Split(argsNullSep, "\x00")
-
-	origStdout, err := syscall.Dup(int(os.Stdout.Fd()))
+// An in-progress `rclone authorize` flow. Only one session can be active at a
+// time because the local redirect server always listens on the same port.
+type RbAuthorizeSession struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Closed once url is set or the session finishes.
+	urlReady chan struct{}
+	urlOnce  goSync.Once
+	url      string
+	// Closed once the session finishes.
+	done chan struct{}
+	// Only valid after done is closed.
+	token string
+	err   error
+}
+
+var (
+	authorizeLock    goSync.Mutex
+	authorizeSession *RbAuthorizeSession
+)
+
+func init() {
+	// oauthutil calls this with the URL of the local server once it is ready
+	// to accept connections. This is the only way to obtain the URL without
+	// scraping the logs.
+	oauthutil.OpenURL = func(url string) error {
+		authorizeLock.Lock()
+		session := authorizeSession
+		authorizeLock.Unlock()
+
+		if session == nil {
+			return errors.New("no authorize session is active")
+		}
+
+		session.setUrl(url)
+
+		return nil
+	}
+}
+
+func (session *RbAuthorizeSession) setUrl(url string) {
+	session.urlOnce.Do(func() {
+		session.url = url
+		close(session.urlReady)
+	})
+}
+
+// This is the same as config.Authorize(), except the result is returned instead
+// of being printed to stdout.
+func authorize(ctx context.Context, args []string) (string, error) {
+	ctx, ci := fs.AddConfig(ctx)
+	ci.AutoConfirm = true
+	ctx = fs.ConfigOAuthOnly(ctx)
+
+	switch len(args) {
+	case 1, 2, 3:
+	default:
+		return "", fmt.Errorf("invalid number of arguments: %d", len(args))
+	}
+
+	ri, err := fs.Find(args[0])
 	if err != nil {
-		assignError(errOut, err, syscall.EIO)
-		return false
+		return "", err
+	} else if ri.Config == nil {
+		return "", fmt.Errorf("can't authorize fs %q", args[0])
 	}
-	defer syscall.Close(origStdout)
 
-	if err = syscall.Dup3(int(os.Stderr.Fd()), int(os.Stdout.Fd()), 0); err != nil {
-		assignError(errOut, err, syscall.EIO)
-		return false
+	inM := configmap.Simple{}
+	inM[config.ConfigAuthorize] = "true"
+
+	if len(args) == 2 {
+		err := inM.Decode(args[1])
+		if err != nil {
+			return "", err
+		}
+	} else if len(args) == 3 {
+		inM[config.ConfigClientID] = args[1]
+		inM[config.ConfigClientSecret] = args[2]
 	}
 
-	if err = config.Authorize(context.Background(), args, true, ""); err != nil {
-		assignError(errOut, err, syscall.EIO)
-		return false
+	name := "**temp-fs**"
+
+	m := fs.ConfigMap(ri.Prefix, ri.Options, name, inM)
+	outM := configmap.Simple{}
+	m.ClearSetters()
+	m.AddSetter(outM)
+	m.AddGetter(outM, configmap.PriorityNormal)
+
+	err = config.PostConfig(ctx, name, m, ri)
+	if err != nil {
+		return "", err
+	}
+
+	if len(args) == 2 {
+		return outM.Encode()
 	}
 
-	if err = syscall.Dup3(int(origStdout), int(os.Stdout.Fd()), 0); err != nil {
+	return outM["token"], nil
+}
+
+// Start an `rclone authorize` flow. The arguments are the arguments that would
+// be passed to `rclone authorize`, separated by null bytes. Fails with EBUSY if
+// another session is already active.
+func RbAuthorizeStart(argsNullSep string, errOut *RbError) *RbAuthorizeSession {
+	authorizeLock.Lock()
+	defer authorizeLock.Unlock()
+
+	if authorizeSession != nil {
+		assignError(errOut, syscall.EBUSY, syscall.EBUSY)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	session := &RbAuthorizeSession{
+		ctx:      ctx,
+		cancel:   cancel,
+		urlReady: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	authorizeSession = session
+
+	go func() {
+		session.token, session.err = authorize(ctx, strings.Split(argsNullSep, "\x00"))
+
+		authorizeLock.Lock()
+		authorizeSession = nil
+		authorizeLock.Unlock()
+
+		// Unblock WaitUrl() if the flow failed before the server started.
+		session.setUrl("")
+		close(session.done)
+	}()
+
+	return session
+}
+
+type RbAuthorizeUrlResult struct {
+	Url string
+}
+
+// Wait for the local redirect server to start and return the URL that the user
+// should visit to authorize access. If the session finishes without ever
+// starting the server, then the session's error is returned.
+func (session *RbAuthorizeSession) WaitUrl(errOut *RbError) *RbAuthorizeUrlResult {
+	<-session.urlReady
+
+	if session.url == "" {
+		<-session.done
+
+		err := session.err
+		if err == nil {
+			err = errors.New("authorize flow finished without a URL")
+		}
+
 		assignError(errOut, err, syscall.EIO)
-		return false
+		return nil
 	}
 
-	return true
+	return &RbAuthorizeUrlResult{
+		Url: session.url,
+	}
+}
+
+type RbAuthorizeTokenResult struct {
+	// The token JSON or config blob to be pasted into the remote's config.
+	Token string
+}
+
+// Wait for the user to complete the authorization and return the token. If the
+// session was cancelled, this fails with ECANCELED.
+func (session *RbAuthorizeSession) Wait(errOut *RbError) *RbAuthorizeTokenResult {
+	<-session.done
+
+	if session.err != nil {
+		// oauthutil returns a plain error when the context is cancelled.
+		if session.ctx.Err() != nil {
+			assignError(errOut, session.ctx.Err(), syscall.ECANCELED)
+		} else {
+			assignError(errOut, session.err, syscall.EIO)
+		}
+		return nil
+	}
+
+	return &RbAuthorizeTokenResult{
+		Token: session.token,
+	}
+}
+
+// Cancel the session and stop the local redirect server. This is idempotent and
+// can be called from any thread.
+func (session *RbAuthorizeSession) Cancel() {
+	session.cancel()
 }
 
 func RbAuthorizeUrl() string {
@@ -0,0 +1,234 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+)
+
+const (
+	// Creating the backend instance, which includes authenticating for most
+	// backends.
+	RbRemoteTestStepCreate = iota
+	// Listing the root directory.
+	RbRemoteTestStepList
+	// Uploading the temporary file.
+	RbRemoteTestStepWrite
+	// Downloading the temporary file and comparing its contents.
+	RbRemoteTestStepRead
+	// Deleting the temporary file.
+	RbRemoteTestStepDelete
+)
+
+const (
+	remoteTestDefaultTimeout = 30 * time.Second
+	remoteTestFilePrefix     = ".rsaf-test-"
+)
+
+type RbRemoteTestOptions struct {
+	// Timeout for the whole test in seconds. 0 means the default of 30
+	// seconds.
+	TimeoutSeconds int64
+	// Whether to write, read back, and delete a temporary file in the root of
+	// the remote after listing it.
+	WriteTest bool
+}
+
+type RbRemoteTestResult struct {
+	Success bool
+	// The RbRemoteTestStep* constant for the step that failed or -1 if the
+	// test succeeded.
+	FailedStep int
+	// The error from the failed step or nil if the test succeeded. The error
+	// category is the classified failure reason. Timeouts are reported as
+	// ETIMEDOUT network errors.
+	Error *RbError
+	// The time taken by each step in milliseconds or -1 if the step did not
+	// run.
+	CreateMillis int64
+	ListMillis   int64
+	WriteMillis  int64
+	ReadMillis   int64
+	DeleteMillis int64
+	// Number of entries in the root directory.
+	RootEntries int64
+	// The features supported by the remote or nil if the backend instance
+	// could not be created.
+	Features *RbRemoteFeaturesResult
+}
+
+// Shut down a backend instance that was created outside of the cache.
+func shutdownFs(f fs.Fs) {
+	doShutdown := f.Features().Shutdown
+	if doShutdown == nil {
+		return
+	}
+
+	err := doShutdown(context.Background())
+	if err != nil {
+		fs.Logf(f, "Failed to shut down backend: %v", err)
+	}
+}
+
+// Run a test step and record how long it took.
+func timeRemoteTestStep(millis *int64, step func() error) error {
+	start := time.Now()
+	err := step()
+	*millis = time.Since(start).Milliseconds()
+
+	return err
+}
+
+// Upload a temporary file, read it back, and then delete it.
+func runRemoteTestRoundTrip(ctx context.Context, f fs.Fs, result *RbRemoteTestResult) (int, error) {
+	name := remoteTestFilePrefix + random.String(16)
+	data := []byte(random.String(64))
+
+	var obj fs.Object
+
+	err := timeRemoteTestStep(&result.WriteMillis, func() error {
+		info := object.NewStaticObjectInfo(name, time.Now(), int64(len(data)), true, nil, f)
+
+		var err error
+		obj, err = f.Put(ctx, bytes.NewReader(data), info)
+		return err
+	})
+	if err != nil {
+		return RbRemoteTestStepWrite, err
+	}
+
+	readErr := timeRemoteTestStep(&result.ReadMillis, func() error {
+		reader, err := obj.Open(ctx)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		readData, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		} else if !bytes.Equal(readData, data) {
+			return fmt.Errorf("%w: read back %d bytes that do not match the %d bytes written",
+				syscall.EIO, len(readData), len(data))
+		}
+
+		return nil
+	})
+
+	// Always try to clean up, even if reading failed.
+	err = timeRemoteTestStep(&result.DeleteMillis, func() error {
+		return obj.Remove(ctx)
+	})
+
+	if readErr != nil {
+		if err != nil {
+			fs.Logf(f, "Failed to delete test file: %q: %v", name, err)
+		}
+		return RbRemoteTestStepRead, readErr
+	} else if err != nil {
+		return RbRemoteTestStepDelete, err
+	}
+
+	return -1, nil
+}
+
+// Check that a remote works by creating a new backend instance for it,
+// bypassing the cache, and listing the root directory. If options.WriteTest is
+// true, a temporary file is also written, read back, and deleted. A failing
+// test is not an error. The result reports which step failed and why. errOut is
+// only set if the arguments are invalid or the token is cancelled.
+func RbRemoteTest(remote string, options *RbRemoteTestOptions, token *RbCancelToken, errOut *RbError) *RbRemoteTestResult {
+	if options == nil {
+		options = &RbRemoteTestOptions{}
+	}
+
+	if options.TimeoutSeconds < 0 {
+		assignError(errOut, syscall.EINVAL, syscall.EINVAL)
+		return nil
+	}
+
+	timeout := remoteTestDefaultTimeout
+	if options.TimeoutSeconds > 0 {
+		timeout = time.Duration(options.TimeoutSeconds) * time.Second
+	}
+
+	result := &RbRemoteTestResult{
+		FailedStep:   -1,
+		CreateMillis: -1,
+		ListMillis:   -1,
+		WriteMillis:  -1,
+		ReadMillis:   -1,
+		DeleteMillis: -1,
+	}
+
+	fail := func(step int, err error) *RbRemoteTestResult {
+		if err := tokenContext(token).Err(); err != nil {
+			assignError(errOut, err, syscall.ECANCELED)
+			return nil
+		}
+
+		result.FailedStep = step
+		result.Error = &RbError{}
+		assignError(result.Error, err, syscall.EIO)
+
+		fs.Logf(remote, "Connection test failed at step %d: %v", step, err)
+
+		return result
+	}
+
+	if err := checkNetwork(remote); err != nil {
+		return fail(RbRemoteTestStepCreate, err)
+	}
+
+	ctx, cancel := context.WithTimeout(tokenContext(token), timeout)
+	defer cancel()
+
+	// Not all backends respect the context when they are being created.
+	testToken := &RbCancelToken{ctx: ctx, cancel: cancel}
+
+	var f fs.Fs
+
+	err := timeRemoteTestStep(&result.CreateMillis, func() error {
+		var err error
+		f, err = runCancellable(testToken, func() (fs.Fs, error) {
+			return fs.NewFs(ctx, remote)
+		}, shutdownFs)
+		return err
+	})
+	if err != nil {
+		return fail(RbRemoteTestStepCreate, err)
+	}
+	defer shutdownFs(f)
+
+	result.Features = newRemoteFeaturesResult(f)
+
+	err = timeRemoteTestStep(&result.ListMillis, func() error {
+		entries, err := f.List(ctx, "")
+		result.RootEntries = int64(len(entries))
+		return err
+	})
+	if err != nil {
+		return fail(RbRemoteTestStepList, err)
+	}
+
+	if options.WriteTest {
+		step, err := runRemoteTestRoundTrip(ctx, f, result)
+		if err != nil {
+			return fail(step, err)
+		}
+	}
+
+	result.Success = true
+
+	return result
+}
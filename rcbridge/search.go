@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	"errors"
+	"path"
+	"regexp"
+	"syscall"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+const (
+	// Match names containing the query.
+	RbSearchModeSubstring = iota
+	// Match names using an rclone filter glob, like "*.jpg". Globs without a
+	// slash match the name only. Globs with a leading slash are anchored to
+	// the directory being searched.
+	RbSearchModeGlob
+	// Match names using a regular expression. The whole name must match and
+	// parent directory names are never matched.
+	RbSearchModeRegex
+)
+
+type RbSearchOptions struct {
+	Mode          int
+	CaseSensitive bool
+	// Stop searching after this many results. 0 means no limit.
+	MaxResults int
+}
+
+var errSearchLimitReached = errors.New("search result limit reached")
+
+// Create a function that reports whether the path of an entry, relative to the
+// directory being searched, matches the query. Substrings and regular
+// expressions only ever match the last path element.
+func newSearchMatcher(query string, options *RbSearchOptions) (func(string) bool, error) {
+	var pattern string
+
+	switch options.Mode {
+	case RbSearchModeSubstring:
+		pattern = ".*" + regexp.QuoteMeta(query) + ".*"
+	case RbSearchModeGlob:
+		return newGlobMatcher(query, options)
+	case RbSearchModeRegex:
+		pattern = query
+	default:
+		return nil, syscall.EINVAL
+	}
+
+	// rclone's filter rules match against the whole path, so a pattern like
+	// ".*" would be able to match across directories.
+	flags := "s"
+	if !options.CaseSensitive {
+		flags += "i"
+	}
+
+	re, err := regexp.Compile("(?" + flags + ")^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(relPath string) bool {
+		return re.MatchString(path.Base(relPath))
+	}, nil
+}
+
+// Create a function that matches paths against an rclone filter glob.
+func newGlobMatcher(glob string, options *RbSearchOptions) (func(string) bool, error) {
+	fi, err := filter.NewFilter(&filter.Options{
+		RulesOpt: filter.RulesOpt{
+			IncludeRule: []string{glob},
+		},
+		MinAge:     fs.DurationOff,
+		MaxAge:     fs.DurationOff,
+		MinSize:    fs.SizeSuffix(-1),
+		MaxSize:    fs.SizeSuffix(-1),
+		IgnoreCase: !options.CaseSensitive,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fi.IncludeRemote, nil
+}
+
+// Recursively search a directory for files and directories whose names match
+// the query. Results are retrieved with Next() in the same way as
+// RbDocListDirOpen() and are not sorted. The backend's ListR is used if it is
+// supported. Otherwise, the directories are walked in parallel. Close() must be
+// called when the search is no longer needed, even if it is complete.
+// Cancelling the token has the same effect as calling Close().
+func RbDocSearch(rootDoc string, query string, options *RbSearchOptions, token *RbCancelToken, errOut *RbError) *RbDirListing {
+	if options == nil {
+		options = &RbSearchOptions{}
+	}
+
+	if query == "" || options.MaxResults < 0 {
+		assignError(errOut, syscall.EINVAL, syscall.EINVAL)
+		return nil
+	}
+
+	if err := checkNetworkForDoc(rootDoc); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return nil
+	}
+
+	matches, err := newSearchMatcher(query, options)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	v, docPath, err := getVfsForDoc(rootDoc)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	node, err := v.Stat(docPath)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	} else if !node.IsDir() {
+		assignError(errOut, syscall.ENOTDIR, syscall.ENOTDIR)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(tokenContext(token))
+
+	listing := newDirListing(ctx, cancel, v, rootDoc, docPath)
+
+	go listing.run(func(callback fs.ListRCallback) error {
+		found := 0
+
+		// The matcher must not be implemented by adding a filter to the
+		// context because that would prevent descending into directories that
+		// don't match.
+		err := walk.ListR(ctx, listing.f, docPath, true, -1, walk.ListAll, func(entries fs.DirEntries) error {
+			results := fs.DirEntries{}
+
+			for _, entry := range entries {
+				if matches(listing.relPath(entry)) {
+					results = append(results, entry)
+				}
+			}
+
+			limitReached := false
+			if options.MaxResults > 0 && found+len(results) >= options.MaxResults {
+				results = results[:options.MaxResults-found]
+				limitReached = true
+			}
+
+			found += len(results)
+
+			if len(results) > 0 {
+				if err := callback(results); err != nil {
+					return err
+				}
+			}
+
+			if limitReached {
+				return errSearchLimitReached
+			}
+
+			return nil
+		})
+		if errors.Is(err, errSearchLimitReached) {
+			return nil
+		}
+
+		return err
+	})
+
+	return listing
+}
@@ -0,0 +1,338 @@
+// SPDX-FileCopyrightText: 2026 Andrew Gunnerson
+// SPDX-License-Identifier: GPL-3.0-only
+
+package rcbridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	goSync "sync"
+	"syscall"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscache/writeback"
+)
+
+const (
+	// Waiting for the writeback delay or for the delay before the next retry.
+	RbVfsUploadStateQueued = iota
+	RbVfsUploadStateUploading
+	// Postponed by RbVfsUploadCancel().
+	RbVfsUploadStateCancelled
+	// Postponed because the network can't be used. See RbSetNetworkState().
+	RbVfsUploadStatePaused
+)
+
+const (
+	// How long to postpone uploads that are cancelled. rclone has no way to
+	// remove an item from the upload queue without deleting the file.
+	uploadCancelExpiry = 100 * 365 * 24 * time.Hour
+	// Anything postponed by more than this is considered to be cancelled.
+	uploadCancelThreshold = uploadCancelExpiry / 2
+	// How long to postpone uploads while the network can't be used. This must
+	// be less than uploadCancelThreshold.
+	uploadPauseExpiry = 10 * 365 * 24 * time.Hour
+	// Anything postponed by more than this, but not enough to be considered
+	// cancelled, is considered to be paused.
+	uploadPauseThreshold = uploadPauseExpiry / 2
+	// How often to check the upload queue in RbVfsUploadWait().
+	uploadWaitInterval = 500 * time.Millisecond
+	// rclone's log message when an upload attempt fails.
+	uploadErrorPrefix = "vfs cache: failed to upload "
+)
+
+type uploadKey struct {
+	remote string
+	path   string
+}
+
+var (
+	uploadErrorsLock goSync.Mutex
+	// The last upload error message for each file.
+	uploadErrors = make(map[uploadKey]string)
+)
+
+// Remember the message if it's from a failed upload. rclone only reports upload
+// errors via logging and the log message doesn't include the remote, so the
+// error is attributed to every remote that has a queued upload for the path
+// with a matching number of attempts.
+func recordUploadError(object string, message string) {
+	if !strings.HasPrefix(message, uploadErrorPrefix) {
+		return
+	}
+
+	var tries int
+	if _, err := fmt.Sscanf(message, uploadErrorPrefix+"try #%d,", &tries); err != nil {
+		return
+	}
+
+	// This is called while the writeback queue is locked, so the queue can
+	// only be inspected after rclone is done logging.
+	go func() {
+		for remote, state := range getVfsStates() {
+			v := state.v.Load()
+			if v == nil {
+				continue
+			}
+
+			queue, err := getUploadQueue(v)
+			if err != nil {
+				continue
+			}
+
+			for _, item := range queue {
+				if item.Name == object && item.Tries == tries {
+					uploadErrorsLock.Lock()
+					uploadErrors[uploadKey{remote, object}] = message
+					uploadErrorsLock.Unlock()
+
+					// The item was queued again for a retry.
+					repauseUploads(v)
+				}
+			}
+		}
+	}()
+}
+
+type RbVfsUpload struct {
+	// ID of the upload within the remote's VFS instance.
+	Id    int64
+	Doc   string
+	Size  int64
+	State int
+	// Number of upload attempts so far.
+	Attempts int
+	// Number of seconds until the next attempt. This may be negative if the
+	// attempt is overdue because too many other uploads are in progress.
+	NextAttempt float64
+	// The error message from the last failed attempt or an empty string if
+	// there is none.
+	LastError string
+}
+
+type RbVfsUploadList struct {
+	items []RbVfsUpload
+}
+
+func (list *RbVfsUploadList) Get(index int) *RbVfsUpload {
+	return &list.items[index]
+}
+
+func (list *RbVfsUploadList) Size() int {
+	return len(list.items)
+}
+
+// Call one of the VFS RC functions, which are the only public way to access
+// the VFS cache's upload queue.
+func callVfsRc(v *vfs.VFS, method string, params rc.Params) (rc.Params, error) {
+	params["fs"] = fs.ConfigString(v.Fs())
+
+	return rc.Calls.Get(method).Fn(context.Background(), params)
+}
+
+func getUploadQueue(v *vfs.VFS) ([]writeback.QueueInfo, error) {
+	out, err := callVfsRc(v, "vfs/queue", rc.Params{})
+	if err != nil {
+		return nil, err
+	} else if out == nil {
+		// Caching is disabled.
+		return nil, nil
+	}
+
+	queue, ok := out["queue"].([]writeback.QueueInfo)
+	if !ok {
+		return nil, fmt.Errorf("unexpected upload queue type: %T", out["queue"])
+	}
+
+	return queue, nil
+}
+
+// List the files in the remote's VFS cache that are waiting to be uploaded or
+// are being uploaded. The list is empty if caching is disabled or the VFS has
+// not been created. Uploads that are in progress are listed first, followed by
+// the rest in the order they will be uploaded.
+func RbVfsListUploads(remote string, errOut *RbError) *RbVfsUploadList {
+	uploads := []RbVfsUpload{}
+
+	v, err := getExistingVfs(remote)
+	if err == syscall.ENOENT {
+		return &RbVfsUploadList{items: uploads}
+	} else if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return nil
+	}
+
+	queue, err := getUploadQueue(v)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return nil
+	}
+
+	uploadErrorsLock.Lock()
+	defer uploadErrorsLock.Unlock()
+
+	queued := make(map[uploadKey]struct{})
+
+	for _, item := range queue {
+		upload := RbVfsUpload{
+			Id:          int64(item.ID),
+			Doc:         fspath.JoinRootPath(remote, item.Name),
+			Size:        item.Size,
+			State:       RbVfsUploadStateQueued,
+			Attempts:    item.Tries,
+			NextAttempt: item.Expiry,
+		}
+
+		if item.Uploading {
+			upload.State = RbVfsUploadStateUploading
+		} else if item.Expiry > uploadCancelThreshold.Seconds() {
+			upload.State = RbVfsUploadStateCancelled
+		} else if item.Expiry > uploadPauseThreshold.Seconds() {
+			upload.State = RbVfsUploadStatePaused
+		}
+
+		key := uploadKey{remote, item.Name}
+
+		if item.Tries > 0 {
+			upload.LastError = uploadErrors[key]
+		}
+
+		queued[key] = struct{}{}
+		uploads = append(uploads, upload)
+	}
+
+	// Forget the errors for this remote's uploads that are no longer queued.
+	for key := range uploadErrors {
+		if _, ok := queued[key]; !ok && key.remote == remote {
+			delete(uploadErrors, key)
+		}
+	}
+
+	return &RbVfsUploadList{items: uploads}
+}
+
+func setUploadExpiry(remote string, id int64, expiry time.Duration) error {
+	v, err := getExistingVfs(remote)
+	if err != nil {
+		return err
+	}
+
+	_, err = callVfsRc(v, "vfs/queue-set-expiry", rc.Params{
+		"id":     id,
+		"expiry": expiry.Seconds(),
+	})
+	if err != nil && strings.Contains(err.Error(), writeback.ErrorIDNotFound.Error()) {
+		return syscall.ENOENT
+	}
+
+	return err
+}
+
+// Upload a queued file as soon as possible, skipping the remaining writeback
+// or retry delay. This also resumes uploads cancelled by RbVfsUploadCancel().
+// Fails with ENOENT if the upload is no longer queued and ENETUNREACH if the
+// remote's uploads are paused because the network can't be used.
+func RbVfsUploadRetry(remote string, id int64, errOut *RbError) bool {
+	if err := checkNetwork(remote); err != nil {
+		assignError(errOut, err, syscall.ENETUNREACH)
+		return false
+	}
+
+	// Negative expiry times are overdue, so they're uploaded right away.
+	err := setUploadExpiry(remote, id, -uploadCancelExpiry)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	return true
+}
+
+// Cancel a queued upload. rclone cannot remove files from the upload queue
+// without deleting them, so the upload is postponed indefinitely instead. The
+// file is kept in the VFS cache and will be uploaded if RbVfsUploadRetry() is
+// called or the VFS is recreated. Fails with EBUSY if the file is currently
+// being uploaded and ENOENT if it is no longer queued.
+func RbVfsUploadCancel(remote string, id int64, errOut *RbError) bool {
+	v, err := getExistingVfs(remote)
+	if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return false
+	}
+
+	queue, err := getUploadQueue(v)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	for _, item := range queue {
+		if int64(item.ID) == id && item.Uploading {
+			assignError(errOut, syscall.EBUSY, syscall.EBUSY)
+			return false
+		}
+	}
+
+	err = setUploadExpiry(remote, id, uploadCancelExpiry)
+	if err != nil {
+		assignError(errOut, err, syscall.EIO)
+		return false
+	}
+
+	return true
+}
+
+// Wait until the remote's upload queue is empty, ignoring uploads cancelled by
+// RbVfsUploadCancel(). This returns immediately if the VFS has not been
+// created. Fails with ENETUNREACH if the only remaining uploads are paused
+// because the network can't be used.
+func RbVfsUploadWait(remote string, token *RbCancelToken, errOut *RbError) bool {
+	v, err := getExistingVfs(remote)
+	if err == syscall.ENOENT {
+		return true
+	} else if err != nil {
+		assignError(errOut, err, syscall.EINVAL)
+		return false
+	}
+
+	ctx := tokenContext(token)
+	ticker := time.NewTicker(uploadWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		queue, err := getUploadQueue(v)
+		if err != nil {
+			assignError(errOut, err, syscall.EIO)
+			return false
+		}
+
+		remaining := 0
+		paused := 0
+		for _, item := range queue {
+			if item.Uploading || item.Expiry <= uploadPauseThreshold.Seconds() {
+				remaining++
+			} else if item.Expiry <= uploadCancelThreshold.Seconds() {
+				paused++
+			}
+		}
+
+		if remaining == 0 && paused == 0 {
+			return true
+		} else if remaining == 0 {
+			assignError(errOut, syscall.ENETUNREACH, syscall.ENETUNREACH)
+			return false
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			assignError(errOut, ctx.Err(), syscall.ECANCELED)
+			return false
+		}
+	}
+}